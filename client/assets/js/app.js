@@ -133,12 +133,7 @@ async function loadConversationsFromAPI() {
 
     try {
         loadingConversations = true;
-        const user = JSON.parse(userData);
-        const response = await fetch('http://localhost:8080/api/conversations', {
-            headers: {
-                'Authorization': `Bearer ${user.token}`
-            }
-        });
+        const response = await authFetch('http://localhost:8080/api/conversations');
 
         if (response.status === 429) {
             console.warn('⚠️ Rate limited. Will retry in 3 seconds...');
@@ -188,12 +183,7 @@ async function loadFriendRequestsFromAPI() {
 
     try {
         loadingFriendRequests = true;
-        const user = JSON.parse(userData);
-        const response = await fetch('http://localhost:8080/api/friends/requests', {
-            headers: {
-                'Authorization': `Bearer ${user.token}`
-            }
-        });
+        const response = await authFetch('http://localhost:8080/api/friends/requests');
 
         if (response.status === 429) {
             console.warn('⚠️ Rate limited. Will retry in 4 seconds...');
@@ -237,12 +227,7 @@ async function loadFriendsFromAPI() {
 
     try {
         loadingFriends = true;
-        const user = JSON.parse(userData);
-        const response = await fetch('http://localhost:8080/api/friends', {
-            headers: {
-                'Authorization': `Bearer ${user.token}`
-            }
-        });
+        const response = await authFetch('http://localhost:8080/api/friends');
 
         if (response.status === 429) {
             console.warn('⚠️ Rate limited. Will retry in 2 seconds...');
@@ -470,12 +455,10 @@ async function acceptFriendRequest(id) {
             requestItem.style.pointerEvents = 'none';
         }
         
-        const user = JSON.parse(userData);
-        const response = await fetch('http://localhost:8080/api/friends/accept', {
+        const response = await authFetch('http://localhost:8080/api/friends/accept', {
             method: 'POST',
             headers: {
-                'Content-Type': 'application/json',
-                'Authorization': `Bearer ${user.token}`
+                'Content-Type': 'application/json'
             },
             body: JSON.stringify({ friend_id: id })
         });
@@ -540,12 +523,10 @@ async function declineFriendRequest(id) {
             requestItem.style.pointerEvents = 'none';
         }
         
-        const user = JSON.parse(userData);
-        const response = await fetch('http://localhost:8080/api/friends/reject', {
+        const response = await authFetch('http://localhost:8080/api/friends/reject', {
             method: 'POST',
             headers: {
-                'Content-Type': 'application/json',
-                'Authorization': `Bearer ${user.token}`
+                'Content-Type': 'application/json'
             },
             body: JSON.stringify({ friend_id: id })
         });
@@ -30,11 +30,11 @@ async function apiCall(url, options = {}) {
 
   const headers = {
     'Content-Type': 'application/json',
-    'Authorization': `Bearer ${token}`,
     ...options.headers
   };
 
-  const response = await fetch(url, { ...options, headers });
+  // authFetch (websocket.js) gắn token và tự làm mới khi hết hạn
+  const response = await authFetch(url, { ...options, headers });
   if (!response.ok) {
     throw new Error(`API call failed: ${response.statusText}`);
   }
@@ -63,6 +63,8 @@ document.addEventListener('DOMContentLoaded', function() {
                         username: response.user.username,
                         avatar: response.user.avatar_url || '',
                         token: response.token,
+                        refreshToken: response.refreshToken,
+                        tokenExpiresAt: Date.now() + response.expiresIn * 1000,
                         loginTime: new Date().toISOString()
                     };
                     
@@ -128,7 +130,9 @@ document.addEventListener('DOMContentLoaded', function() {
                         email: data.user.email,
                         avatar: data.user.avatar_url
                     },
-                    token: data.token
+                    token: data.token,
+                    refreshToken: data.refresh_token,
+                    expiresIn: data.expires_in
                 };
             } else {
                 throw new Error(data.message || 'Đăng nhập thất bại');
@@ -8,6 +8,137 @@ let heartbeatInterval = null;
 let currentUser = null;
 let activeConversation = null; // Store current conversation user
 let unreadMessages = {}; // Track unread messages per user: {userId: count}
+let lastSeq = null; // Seq của frame mới nhất đã nhận, gửi lại khi reconnect để replay
+let catchUpFrames = 0; // Số frame replay/offline sau frame session (đã tính trong unread_counts)
+let tokenRefreshTimer = null; // Làm mới access token trước khi hết hạn
+let tokenRefreshPromise = null; // Request refresh đang chạy, dùng chung cho các lời gọi đồng thời
+
+// ========================
+// 🔑 Access token & refresh token
+// ========================
+
+// Đọc session đã lưu (localStorage nếu "ghi nhớ đăng nhập", ngược lại sessionStorage)
+function getStoredUser() {
+    const userData = localStorage.getItem('user') || sessionStorage.getItem('user');
+    if (!userData) return null;
+    try {
+        return JSON.parse(userData);
+    } catch (e) {
+        return null;
+    }
+}
+
+// Ghi session vào đúng nơi đã lưu lúc đăng nhập
+function storeUser(user) {
+    const storage = localStorage.getItem('user') ? localStorage : sessionStorage;
+    storage.setItem('user', JSON.stringify(user));
+}
+
+// Session không còn dùng được: xóa và quay về trang đăng nhập
+function redirectToLogin() {
+    localStorage.removeItem('user');
+    sessionStorage.removeItem('user');
+    window.location.href = 'login.html';
+}
+
+// Đổi refresh token lấy access token mới. Refresh token chỉ dùng được một lần
+// (dùng lại sẽ bị thu hồi cả session), nên các tab dùng chung một khóa và
+// đọc lại token mà tab khác vừa lấy trước khi tự gọi API.
+function refreshAccessToken() {
+    if (tokenRefreshPromise) return tokenRefreshPromise;
+
+    const staleToken = (getStoredUser() || {}).token;
+    const doRefresh = async () => {
+        const user = getStoredUser();
+        if (!user || !user.refreshToken) {
+            redirectToLogin();
+            throw new Error('No refresh token');
+        }
+        if (user.token !== staleToken) {
+            return user.token; // Tab khác đã làm mới
+        }
+
+        const response = await fetch('http://localhost:8080/api/auth/refresh', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ refresh_token: user.refreshToken })
+        });
+        const data = await response.json().catch(() => ({}));
+        if (response.status === 401) {
+            console.log('🚪 Refresh token rejected. Redirecting to login...');
+            redirectToLogin();
+            throw new Error(data.message || 'Session expired');
+        }
+        if (!response.ok || !data.success) {
+            throw new Error(data.message || 'Failed to refresh token');
+        }
+
+        user.token = data.token;
+        user.refreshToken = data.refresh_token;
+        user.tokenExpiresAt = Date.now() + data.expires_in * 1000;
+        storeUser(user);
+        console.log('🔑 Access token refreshed');
+        return user.token;
+    };
+
+    const locked = navigator.locks
+        ? navigator.locks.request('e5-token-refresh', doRefresh)
+        : doRefresh();
+    tokenRefreshPromise = locked
+        .then(token => {
+            if (currentUser) currentUser.token = token;
+            scheduleTokenRefresh();
+            return token;
+        })
+        .finally(() => {
+            tokenRefreshPromise = null;
+        });
+    return tokenRefreshPromise;
+}
+
+// Hẹn giờ làm mới access token một phút trước khi hết hạn
+function scheduleTokenRefresh() {
+    const user = getStoredUser();
+    if (tokenRefreshTimer) clearTimeout(tokenRefreshTimer);
+    if (!user || !user.refreshToken || !user.tokenExpiresAt) return;
+
+    const delay = Math.max(user.tokenExpiresAt - Date.now() - 60 * 1000, 0);
+    tokenRefreshTimer = setTimeout(() => {
+        refreshAccessToken().catch(error => console.error('❌ Token refresh failed:', error));
+    }, delay);
+}
+
+// Trả về access token còn hạn, làm mới trước nếu sắp hết hạn
+async function getValidToken() {
+    const user = getStoredUser();
+    if (!user || !user.token) {
+        redirectToLogin();
+        throw new Error('No authentication token');
+    }
+    if (user.refreshToken && user.tokenExpiresAt && user.tokenExpiresAt - Date.now() < 30 * 1000) {
+        return refreshAccessToken();
+    }
+    return user.token;
+}
+
+// fetch kèm access token; gặp 401 thì làm mới token và thử lại một lần
+async function authFetch(url, options = {}) {
+    const send = token => fetch(url, {
+        ...options,
+        headers: { ...(options.headers || {}), 'Authorization': `Bearer ${token}` }
+    });
+
+    let response = await send(await getValidToken());
+    if (response.status === 401) {
+        const user = getStoredUser();
+        if (!user || !user.refreshToken) {
+            redirectToLogin(); // Session cũ, trước khi có refresh token
+            return response;
+        }
+        response = await send(await refreshAccessToken());
+    }
+    return response;
+}
 
 // Khởi tạo kết nối WebSocket
 function initWebSocket() {
@@ -55,8 +186,38 @@ function initWebSocket() {
         return;
     }
 
+    // Token hết hạn thì server từ chối handshake, làm mới trước khi kết nối
+    getValidToken()
+        .then(token => {
+            currentUser.token = token;
+            scheduleTokenRefresh();
+            connectWebSocket();
+        })
+        .catch(error => {
+            console.error('❌ Cannot get a valid token:', error);
+            scheduleReconnect();
+        });
+}
+
+// Tự động reconnect sau 5 giây
+function scheduleReconnect() {
+    if (!reconnectInterval) {
+        console.log('🔄 Will reconnect in 5 seconds...');
+        reconnectInterval = setTimeout(() => {
+            reconnectInterval = null;
+            console.log('🔄 Reconnecting...');
+            initWebSocket();
+        }, 5000);
+    }
+}
+
+// Mở kết nối WebSocket với access token hiện tại
+function connectWebSocket() {
     // Kết nối WebSocket với token
-    const wsUrl = `ws://localhost:8080/ws?token=${currentUser.token}`;
+    let wsUrl = `ws://localhost:8080/ws?token=${currentUser.token}`;
+    if (lastSeq !== null) {
+        wsUrl += `&last_seq=${lastSeq}`;
+    }
     console.log('🔌 Connecting to WebSocket:', wsUrl);
 
     ws = new WebSocket(wsUrl);
@@ -80,12 +241,19 @@ function initWebSocket() {
 
     ws.onmessage = function(event) {
         console.log('📨 Message received:', event.data);
-        try {
-            const message = JSON.parse(event.data);
-            handleIncomingMessage(message);
-        } catch (e) {
-            console.error('❌ Failed to parse message:', e);
-        }
+        // Server có thể gộp nhiều frame trong một message, mỗi frame một dòng
+        event.data.split('\n').forEach(function(line) {
+            if (!line) return;
+            try {
+                const message = JSON.parse(line);
+                const catchingUp = catchUpFrames > 0;
+                if (catchingUp) catchUpFrames--;
+                trackSeq(message);
+                handleIncomingMessage(message, catchingUp);
+            } catch (e) {
+                console.error('❌ Failed to parse message:', e);
+            }
+        });
     };
 
     ws.onerror = function(error) {
@@ -107,14 +275,7 @@ function initWebSocket() {
             return;
         }
 
-        // Tự động reconnect sau 5 giây
-        if (!reconnectInterval) {
-            console.log('🔄 Will reconnect in 5 seconds...');
-            reconnectInterval = setTimeout(() => {
-                console.log('🔄 Reconnecting...');
-                initWebSocket();
-            }, 5000);
-        }
+        scheduleReconnect();
     };
 }
 
@@ -134,8 +295,21 @@ function sendMessage(messageObj) {
     }
 }
 
+// Ghi nhớ seq mới nhất để resume phiên khi reconnect
+function trackSeq(message) {
+    if (message.type === 'session') {
+        if (!message.resumed || lastSeq === null) {
+            lastSeq = message.last_seq;
+        }
+        return;
+    }
+    if (message.seq && (lastSeq === null || message.seq > lastSeq)) {
+        lastSeq = message.seq;
+    }
+}
+
 // Xử lý tin nhắn nhận được
-function handleIncomingMessage(message) {
+function handleIncomingMessage(message, catchingUp) {
     console.log('📥 Incoming message:', message);
     
     const chatMessages = document.querySelector('.chat-messages');
@@ -147,6 +321,20 @@ function handleIncomingMessage(message) {
         return;
     }
 
+    // Handle session summary (first frame after connect)
+    if (message.type === 'session') {
+        console.log('🧾 Session started:', message);
+        if (message.unread_counts) {
+            unreadMessages = {};
+            Object.keys(message.unread_counts).forEach(function(userId) {
+                unreadMessages[userId] = message.unread_counts[userId];
+            });
+            updateNotificationBadges();
+        }
+        catchUpFrames = (message.replayed || 0) + (message.delivered || 0);
+        return;
+    }
+
     // Handle heartbeat acknowledgment
     if (message.type === 'heartbeat_ack') {
         console.log('💚 Heartbeat acknowledged');
@@ -198,7 +386,9 @@ function handleIncomingMessage(message) {
             if (!isChatWithActiveUser && isToMe) {
                 // Message for different conversation - update unread count
                 const senderId = message.from_user_id;
-                unreadMessages[senderId] = (unreadMessages[senderId] || 0) + 1;
+                if (!catchingUp) {
+                    unreadMessages[senderId] = (unreadMessages[senderId] || 0) + 1;
+                }
                 console.log('🔔 Unread count updated:', unreadMessages);
                 updateNotificationBadges();
             }
@@ -209,7 +399,9 @@ function handleIncomingMessage(message) {
             if (!isFromMe && isToMe) {
                 // Incoming message but no active conversation - update unread
                 const senderId = message.from_user_id;
-                unreadMessages[senderId] = (unreadMessages[senderId] || 0) + 1;
+                if (!catchingUp) {
+                    unreadMessages[senderId] = (unreadMessages[senderId] || 0) + 1;
+                }
                 console.log('🔔 Unread count updated:', unreadMessages);
                 updateNotificationBadges();
             }
@@ -311,11 +503,11 @@ function logout() {
             const user = JSON.parse(userData);
             
             // 1. First, call API logout and wait for response
-            fetch('http://localhost:8080/api/auth/logout', {
+            if (tokenRefreshTimer) clearTimeout(tokenRefreshTimer);
+            authFetch('http://localhost:8080/api/auth/logout', {
                 method: 'POST',
                 headers: {
-                    'Content-Type': 'application/json',
-                    'Authorization': `Bearer ${user.token}`
+                    'Content-Type': 'application/json'
                 }
             })
             .then(response => response.json())
@@ -466,12 +658,7 @@ async function loadChatHistory(userId) {
     if (!userData) return;
 
     try {
-        const user = JSON.parse(userData);
-        const response = await fetch(`http://localhost:8080/api/messages/history?user_id=${userId}&limit=50`, {
-            headers: {
-                'Authorization': `Bearer ${user.token}`
-            }
-        });
+        const response = await authFetch(`http://localhost:8080/api/messages/history?user_id=${userId}&limit=50`);
 
         if (!response.ok) {
             throw new Error('Failed to load chat history');
@@ -3,6 +3,7 @@ module e5realtimechat
 go 1.23.0
 
 require (
+	github.com/DATA-DOG/go-sqlmock v1.5.2
 	github.com/gorilla/websocket v1.5.3
 	github.com/lib/pq v1.10.9
 	github.com/rabbitmq/amqp091-go v1.10.0
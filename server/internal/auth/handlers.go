@@ -8,11 +8,16 @@ import (
 	"time"
 )
 
-const tokenDuration = 24 * time.Hour
-
 // Handler wraps AuthService with HTTP handlers
 type Handler struct {
 	service *AuthService
+	hub     SessionHub // disconnects WebSocket clients of revoked sessions
+}
+
+// SessionHub defines methods needed from websocket Hub for session management
+type SessionHub interface {
+	// DisconnectSession closes the connections of a session (0 = every session of the user)
+	DisconnectSession(userID, sessionID int)
 }
 
 // NewHandler creates a new auth handler
@@ -20,12 +25,21 @@ func NewHandler(service *AuthService) *Handler {
 	return &Handler{service: service}
 }
 
+// SetHub sets the WebSocket hub used to disconnect revoked sessions
+func (h *Handler) SetHub(hub SessionHub) {
+	h.hub = hub
+}
+
 // RegisterRoutes registers all auth routes
 func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/api/auth/register", h.handleRegister)
 	mux.HandleFunc("/api/auth/login", h.handleLogin)
 	mux.HandleFunc("/api/auth/logout", h.handleLogout)
 	mux.HandleFunc("/api/auth/me", h.handleMe)
+	mux.HandleFunc("/api/auth/refresh", h.handleRefresh)
+	mux.HandleFunc("/api/auth/logout-all", AuthMiddleware(h.handleLogoutAll))
+	mux.HandleFunc("/api/auth/sessions", AuthMiddleware(h.handleSessions))
+	mux.HandleFunc("/api/auth/sessions/revoke", AuthMiddleware(h.handleRevokeSession))
 }
 
 // RegisterRoutesWithRateLimiter registers auth routes with rate limiting middleware
@@ -34,6 +48,10 @@ func (h *Handler) RegisterRoutesWithRateLimiter(mux *http.ServeMux, rateLimitMid
 	mux.Handle("/api/auth/login", rateLimitMiddleware(http.HandlerFunc(h.handleLogin)))
 	mux.Handle("/api/auth/logout", rateLimitMiddleware(http.HandlerFunc(h.handleLogout)))
 	mux.Handle("/api/auth/me", rateLimitMiddleware(http.HandlerFunc(h.handleMe)))
+	mux.Handle("/api/auth/refresh", rateLimitMiddleware(http.HandlerFunc(h.handleRefresh)))
+	mux.Handle("/api/auth/logout-all", rateLimitMiddleware(AuthMiddleware(h.handleLogoutAll)))
+	mux.Handle("/api/auth/sessions", rateLimitMiddleware(AuthMiddleware(h.handleSessions)))
+	mux.Handle("/api/auth/sessions/revoke", rateLimitMiddleware(AuthMiddleware(h.handleRevokeSession)))
 }
 
 func writeJSON(w http.ResponseWriter, status int, data interface{}) {
@@ -88,7 +106,7 @@ func (h *Handler) handleRegister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := GenerateToken(user, tokenDuration)
+	token, refreshToken, err := h.issueTokens(user, r)
 	if err != nil {
 		log.Printf("❌ Token generation failed: %v", err)
 		writeJSON(w, http.StatusInternalServerError, AuthResponse{
@@ -101,10 +119,12 @@ func (h *Handler) handleRegister(w http.ResponseWriter, r *http.Request) {
 	log.Printf("✅ Registration successful: userID=%d, username=%s", user.ID, user.Username)
 
 	writeJSON(w, http.StatusCreated, AuthResponse{
-		Success: true,
-		Message: "registration successful",
-		Token:   token,
-		User:    user,
+		Success:      true,
+		Message:      "registration successful",
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(accessTokenDuration.Seconds()),
+		User:         user,
 	})
 }
 
@@ -151,7 +171,7 @@ func (h *Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := GenerateToken(user, tokenDuration)
+	token, refreshToken, err := h.issueTokens(user, r)
 	if err != nil {
 		log.Printf("❌ Token generation failed: %v", err)
 		writeJSON(w, http.StatusInternalServerError, AuthResponse{
@@ -164,10 +184,12 @@ func (h *Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
 	log.Printf("✅ Login successful: userID=%d, username=%s", user.ID, user.Username)
 
 	writeJSON(w, http.StatusOK, AuthResponse{
-		Success: true,
-		Message: "login successful",
-		Token:   token,
-		User:    user,
+		Success:      true,
+		Message:      "login successful",
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(accessTokenDuration.Seconds()),
+		User:         user,
 	})
 }
 
@@ -228,10 +250,16 @@ func (h *Handler) handleLogout(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Update user online status
-	if err := h.service.LogoutUser(claims.UserID); err != nil {
-		log.Printf("⚠️ Failed to update online status: %v", err)
+	// End the session so its refresh token can't be used anymore
+	if err := h.service.RevokeSession(claims.UserID, claims.SessionID); err != nil && err != ErrSessionNotFound {
+		log.Printf("⚠️ Failed to revoke session %d: %v", claims.SessionID, err)
 	}
+	if h.hub != nil {
+		h.hub.DisconnectSession(claims.UserID, claims.SessionID)
+	}
+
+	// No presence write here: the user goes offline when their last
+	// connection closes, other tabs and devices may still be connected
 
 	log.Printf("✅ Logout successful: userID=%d", claims.UserID)
 
@@ -297,6 +325,14 @@ func (h *Handler) handleMe(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if IsSessionRevoked(claims.SessionID) {
+		writeJSON(w, http.StatusUnauthorized, AuthResponse{
+			Success: false,
+			Message: "session has been revoked",
+		})
+		return
+	}
+
 	user, err := h.service.GetUserByID(claims.UserID)
 	if err != nil {
 		writeJSON(w, http.StatusNotFound, AuthResponse{
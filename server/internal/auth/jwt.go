@@ -10,6 +10,10 @@ import (
 
 var jwtSecret []byte
 
+// ErrTokenWithoutSession is returned for access tokens issued before login
+// sessions existed: they can't be revoked, so they are no longer accepted
+var ErrTokenWithoutSession = errors.New("token has no session, log in again")
+
 func init() {
 	// Load JWT secret from environment or use default (change in production!)
 	secret := os.Getenv("JWT_SECRET")
@@ -24,15 +28,18 @@ type Claims struct {
 	UserID   int    `json:"user_id"`
 	Email    string `json:"email"`
 	Username string `json:"username"`
+	// SessionID is the user_sessions row the token was issued for
+	SessionID int `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// GenerateToken creates a new JWT token for a user
-func GenerateToken(user *User, duration time.Duration) (string, error) {
+// GenerateToken creates a new JWT access token for a user's session
+func GenerateToken(user *User, sessionID int, duration time.Duration) (string, error) {
 	claims := Claims{
-		UserID:   user.ID,
-		Email:    user.Email,
-		Username: user.Username,
+		UserID:    user.ID,
+		Email:     user.Email,
+		Username:  user.Username,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(duration)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -58,6 +65,9 @@ func ValidateToken(tokenString string) (*Claims, error) {
 	}
 
 	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
+		if claims.SessionID <= 0 {
+			return nil, ErrTokenWithoutSession
+		}
 		return claims, nil
 	}
 
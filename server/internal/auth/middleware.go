@@ -62,6 +62,14 @@ func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
+		if IsSessionRevoked(claims.SessionID) {
+			writeJSON(w, http.StatusUnauthorized, AuthResponse{
+				Success: false,
+				Message: "session has been revoked",
+			})
+			return
+		}
+
 		// Add claims and userID to context
 		ctx := context.WithValue(r.Context(), UserContextKey, claims)
 		ctx = context.WithValue(ctx, UserIDKey, claims.UserID)
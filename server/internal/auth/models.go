@@ -29,8 +29,15 @@ type LoginRequest struct {
 
 // AuthResponse is returned after successful auth
 type AuthResponse struct {
-	Success bool   `json:"success"`
-	Message string `json:"message,omitempty"`
-	Token   string `json:"token,omitempty"`
-	User    *User  `json:"user,omitempty"`
+	Success      bool   `json:"success"`
+	Message      string `json:"message,omitempty"`
+	Token        string `json:"token,omitempty"`         // access token
+	RefreshToken string `json:"refresh_token,omitempty"` // exchanged at /api/auth/refresh, single use
+	ExpiresIn    int    `json:"expires_in,omitempty"`    // access token lifetime in seconds
+	User         *User  `json:"user,omitempty"`
+}
+
+// RefreshRequest is the payload for refreshing an access token
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
 }
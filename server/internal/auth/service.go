@@ -121,42 +121,11 @@ func (s *AuthService) LoginUser(req LoginRequest) (*User, error) {
 		return nil, ErrInvalidCreds
 	}
 
-	// Update online status in PostgreSQL
-	_, err = s.db.Exec("UPDATE users SET is_online = true, last_seen_at = NOW() WHERE id = $1", user.ID)
-	if err != nil {
-		// Log error but don't fail login
-		fmt.Printf("Warning: failed to update online status in DB: %v\n", err)
-	}
-
-	// Also set online in Redis cache
-	if s.cacheService != nil {
-		if err := s.cacheService.SetUserOnline(user.ID); err != nil {
-			fmt.Printf("Warning: failed to set user online in Redis: %v\n", err)
-		}
-	}
-
-	user.IsOnline = true
+	// Online status is only changed by WebSocket connections: the user goes
+	// online with their first connection and offline with their last one
 	return user, nil
 }
 
-// LogoutUser marks user as offline
-func (s *AuthService) LogoutUser(userID int) error {
-	// Update PostgreSQL
-	_, err := s.db.Exec("UPDATE users SET is_online = false, last_seen_at = NOW() WHERE id = $1", userID)
-	if err != nil {
-		return err
-	}
-
-	// Also set offline in Redis cache
-	if s.cacheService != nil {
-		if err := s.cacheService.SetUserOffline(userID); err != nil {
-			fmt.Printf("Warning: failed to set user offline in Redis: %v\n", err)
-		}
-	}
-
-	return nil
-}
-
 // GetUserByID retrieves user by ID
 func (s *AuthService) GetUserByID(userID int) (*User, error) {
 	user := &User{}
@@ -0,0 +1,313 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// issueTokens starts a session for a user and returns its access and refresh tokens
+func (h *Handler) issueTokens(user *User, r *http.Request) (string, string, error) {
+	sessionID, refreshToken, err := h.service.CreateSession(user.ID, clientIP(r), r.UserAgent())
+	if err != nil {
+		return "", "", err
+	}
+	token, err := GenerateToken(user, sessionID, accessTokenDuration)
+	if err != nil {
+		return "", "", err
+	}
+	return token, refreshToken, nil
+}
+
+// trustedProxies are the networks of the reverse proxies allowed to report
+// the client address in X-Real-IP / X-Forwarded-For
+var trustedProxies []*net.IPNet
+
+// SetTrustedProxies sets the reverse proxies whose forwarding headers are
+// trusted, as a comma-separated list of IP addresses or CIDR ranges
+func SetTrustedProxies(list string) error {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		networks = append(networks, network)
+	}
+	trustedProxies = networks
+	return nil
+}
+
+// isTrustedProxy reports whether an address belongs to a trusted proxy
+func isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP address of the client. Forwarding headers are only
+// read from trusted proxies: anyone else could make up the address stored
+// with their session.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !isTrustedProxy(host) {
+		return host
+	}
+
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+	// Proxies append to X-Forwarded-For: the client is the last entry that
+	// isn't one of ours, the ones before it may have been sent by the client
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		host = hop
+		if !isTrustedProxy(hop) {
+			break
+		}
+	}
+	return host
+}
+
+func (h *Handler) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	// Handle CORS preflight
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, AuthResponse{
+			Success: false,
+			Message: "method not allowed",
+		})
+		return
+	}
+
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		writeJSON(w, http.StatusBadRequest, AuthResponse{
+			Success: false,
+			Message: "refresh_token is required",
+		})
+		return
+	}
+
+	user, sessionID, refreshToken, err := h.service.RotateRefreshToken(req.RefreshToken, clientIP(r), r.UserAgent())
+	if err == ErrRefreshTokenReused {
+		// Someone else holds a copy of the token, end the session everywhere
+		log.Printf("🚨 Refresh token reused: userID=%d, session=%d revoked", user.ID, sessionID)
+		if h.hub != nil {
+			h.hub.DisconnectSession(user.ID, sessionID)
+		}
+		writeJSON(w, http.StatusUnauthorized, AuthResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	if err == ErrInvalidRefreshToken || err == ErrUserNotFound {
+		writeJSON(w, http.StatusUnauthorized, AuthResponse{
+			Success: false,
+			Message: ErrInvalidRefreshToken.Error(),
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Refresh failed: %v", err)
+		writeJSON(w, http.StatusInternalServerError, AuthResponse{
+			Success: false,
+			Message: "failed to refresh token",
+		})
+		return
+	}
+
+	token, err := GenerateToken(user, sessionID, accessTokenDuration)
+	if err != nil {
+		log.Printf("❌ Token generation failed: %v", err)
+		writeJSON(w, http.StatusInternalServerError, AuthResponse{
+			Success: false,
+			Message: "failed to generate token",
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, AuthResponse{
+		Success:      true,
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(accessTokenDuration.Seconds()),
+		User:         user,
+	})
+}
+
+// handleSessions lists the caller's sessions (devices logged in)
+func (h *Handler) handleSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, AuthResponse{
+			Success: false,
+			Message: "method not allowed",
+		})
+		return
+	}
+
+	claims, ok := GetUserFromContext(r.Context())
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, AuthResponse{
+			Success: false,
+			Message: "unauthorized",
+		})
+		return
+	}
+
+	sessions, err := h.service.GetSessions(claims.UserID)
+	if err != nil {
+		log.Printf("❌ Failed to get sessions: %v", err)
+		writeJSON(w, http.StatusInternalServerError, AuthResponse{
+			Success: false,
+			Message: "failed to get sessions",
+		})
+		return
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == claims.SessionID
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":  true,
+		"sessions": sessions,
+	})
+}
+
+// handleRevokeSession logs out one of the caller's sessions
+func (h *Handler) handleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		writeJSON(w, http.StatusMethodNotAllowed, AuthResponse{
+			Success: false,
+			Message: "method not allowed",
+		})
+		return
+	}
+
+	claims, ok := GetUserFromContext(r.Context())
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, AuthResponse{
+			Success: false,
+			Message: "unauthorized",
+		})
+		return
+	}
+
+	var req struct {
+		SessionID int `json:"session_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.SessionID <= 0 {
+		writeJSON(w, http.StatusBadRequest, AuthResponse{
+			Success: false,
+			Message: "session_id is required",
+		})
+		return
+	}
+
+	err := h.service.RevokeSession(claims.UserID, req.SessionID)
+	if err == ErrSessionNotFound {
+		writeJSON(w, http.StatusNotFound, AuthResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Failed to revoke session: %v", err)
+		writeJSON(w, http.StatusInternalServerError, AuthResponse{
+			Success: false,
+			Message: "failed to revoke session",
+		})
+		return
+	}
+
+	if h.hub != nil {
+		h.hub.DisconnectSession(claims.UserID, req.SessionID)
+	}
+	log.Printf("🚪 Session %d of user %d revoked", req.SessionID, claims.UserID)
+
+	writeJSON(w, http.StatusOK, AuthResponse{
+		Success: true,
+		Message: "session revoked",
+	})
+}
+
+// handleLogoutAll logs the caller out of every device, including this one
+func (h *Handler) handleLogoutAll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, AuthResponse{
+			Success: false,
+			Message: "method not allowed",
+		})
+		return
+	}
+
+	claims, ok := GetUserFromContext(r.Context())
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, AuthResponse{
+			Success: false,
+			Message: "unauthorized",
+		})
+		return
+	}
+
+	if err := h.service.RevokeAllSessions(claims.UserID); err != nil {
+		log.Printf("❌ Failed to revoke sessions: %v", err)
+		writeJSON(w, http.StatusInternalServerError, AuthResponse{
+			Success: false,
+			Message: "failed to log out all devices",
+		})
+		return
+	}
+
+	if h.hub != nil {
+		h.hub.DisconnectSession(claims.UserID, 0)
+	}
+	log.Printf("🚪 All sessions of user %d revoked", claims.UserID)
+
+	writeJSON(w, http.StatusOK, AuthResponse{
+		Success: true,
+		Message: "logged out of all devices",
+	})
+}
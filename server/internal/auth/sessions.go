@@ -0,0 +1,294 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Token lifetimes. Access tokens are short-lived, clients get a new one with
+// their refresh token, which rotates on every use.
+const (
+	accessTokenDuration  = 15 * time.Minute
+	refreshTokenDuration = 30 * 24 * time.Hour
+)
+
+// Session errors
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reused, session revoked")
+	ErrSessionNotFound     = errors.New("session not found")
+)
+
+// Session is a logged-in device, backed by a row of user_sessions
+type Session struct {
+	ID         int       `json:"id"`
+	IPAddress  string    `json:"ip_address"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"` // session of the request's access token
+}
+
+// newRefreshToken returns a random refresh token and the hash stored in the database
+func newRefreshToken() (string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	return token, hashRefreshToken(token), nil
+}
+
+// hashRefreshToken hashes a refresh token, only hashes are stored
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateSession starts a session for a user who just logged in and returns
+// its ID and refresh token
+func (s *AuthService) CreateSession(userID int, ipAddress, userAgent string) (int, string, error) {
+	refreshToken, hash, err := newRefreshToken()
+	if err != nil {
+		return 0, "", err
+	}
+
+	// Expired sessions of the user are dropped on the way
+	if _, err := s.db.Exec(`DELETE FROM user_sessions WHERE user_id = $1 AND expires_at < NOW()`, userID); err != nil {
+		return 0, "", fmt.Errorf("failed to clean up sessions: %w", err)
+	}
+
+	var sessionID int
+	err = s.db.QueryRow(`
+		INSERT INTO user_sessions (user_id, refresh_token, ip_address, user_agent, expires_at, last_used_at)
+		VALUES ($1, $2, $3, $4, NOW() + make_interval(secs => $5::float8), NOW())
+		RETURNING id
+	`, userID, hash, ipAddress, userAgent, refreshTokenDuration.Seconds()).Scan(&sessionID)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to create session: %w", err)
+	}
+	return sessionID, refreshToken, nil
+}
+
+// RotateRefreshToken exchanges a refresh token for a new one and returns the
+// user, the session and the new refresh token. A refresh token that was
+// already rotated means it leaked: the whole session is revoked and
+// ErrRefreshTokenReused is returned with the revoked session (the user only
+// has its ID set).
+func (s *AuthService) RotateRefreshToken(refreshToken, ipAddress, userAgent string) (*User, int, string, error) {
+	hash := hashRefreshToken(refreshToken)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var sessionID, userID int
+	var expired bool
+	err = tx.QueryRow(`
+		SELECT id, user_id, expires_at < NOW()
+		FROM user_sessions
+		WHERE refresh_token = $1
+		FOR UPDATE
+	`, hash).Scan(&sessionID, &userID, &expired)
+	if err == sql.ErrNoRows {
+		return s.revokeReusedToken(tx, hash)
+	}
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("failed to get session: %w", err)
+	}
+
+	if expired {
+		if _, err := tx.Exec(`DELETE FROM user_sessions WHERE id = $1`, sessionID); err != nil {
+			return nil, 0, "", fmt.Errorf("failed to delete expired session: %w", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, 0, "", fmt.Errorf("failed to commit: %w", err)
+		}
+		return nil, 0, "", ErrInvalidRefreshToken
+	}
+
+	newToken, newHash, err := newRefreshToken()
+	if err != nil {
+		return nil, 0, "", err
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO revoked_refresh_tokens (token_hash, session_id) VALUES ($1, $2)
+	`, hash, sessionID); err != nil {
+		return nil, 0, "", fmt.Errorf("failed to revoke refresh token: %w", err)
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE user_sessions
+		SET refresh_token = $2, ip_address = $3, user_agent = $4, last_used_at = NOW(),
+		    expires_at = NOW() + make_interval(secs => $5::float8)
+		WHERE id = $1
+	`, sessionID, newHash, ipAddress, userAgent, refreshTokenDuration.Seconds()); err != nil {
+		return nil, 0, "", fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, 0, "", fmt.Errorf("failed to commit: %w", err)
+	}
+
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	return user, sessionID, newToken, nil
+}
+
+// revokeReusedToken revokes the session of a refresh token that was already
+// rotated, or reports an unknown token
+func (s *AuthService) revokeReusedToken(tx *sql.Tx, hash string) (*User, int, string, error) {
+	var sessionID, userID int
+	err := tx.QueryRow(`
+		SELECT s.id, s.user_id
+		FROM revoked_refresh_tokens r
+		INNER JOIN user_sessions s ON s.id = r.session_id
+		WHERE r.token_hash = $1
+	`, hash).Scan(&sessionID, &userID)
+	if err == sql.ErrNoRows {
+		return nil, 0, "", ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("failed to check revoked refresh token: %w", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM user_sessions WHERE id = $1`, sessionID); err != nil {
+		return nil, 0, "", fmt.Errorf("failed to revoke session: %w", err)
+	}
+	if err := revokeSessionTokens(sessionID); err != nil {
+		return nil, 0, "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, 0, "", fmt.Errorf("failed to commit: %w", err)
+	}
+
+	return &User{ID: userID}, sessionID, "", ErrRefreshTokenReused
+}
+
+// GetSessions lists the active sessions of a user, most recently used first
+func (s *AuthService) GetSessions(userID int) ([]Session, error) {
+	rows, err := s.db.Query(`
+		SELECT id, COALESCE(ip_address, ''), COALESCE(user_agent, ''), created_at,
+		       COALESCE(last_used_at, created_at), expires_at
+		FROM user_sessions
+		WHERE user_id = $1 AND expires_at > NOW() AND refresh_token IS NOT NULL
+		ORDER BY COALESCE(last_used_at, created_at) DESC
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		if err := rows.Scan(&session.ID, &session.IPAddress, &session.UserAgent,
+			&session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeSession ends one session of a user. Its access tokens stop working
+// right away. The session is kept if its tokens can't be blacklisted, so the
+// revocation can be retried.
+func (s *AuthService) RevokeSession(userID, sessionID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM user_sessions WHERE id = $1 AND user_id = $2`, sessionID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return ErrSessionNotFound
+	}
+
+	if err := revokeSessionTokens(sessionID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit: %w", err)
+	}
+	return nil
+}
+
+// RevokeAllSessions ends every session of a user (log out all devices). No
+// session is ended unless the tokens of all of them could be blacklisted.
+func (s *AuthService) RevokeAllSessions(userID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`DELETE FROM user_sessions WHERE user_id = $1 RETURNING id`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+
+	var sessionIDs []int
+	for rows.Next() {
+		var sessionID int
+		if err := rows.Scan(&sessionID); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan revoked session: %w", err)
+		}
+		sessionIDs = append(sessionIDs, sessionID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+
+	for _, sessionID := range sessionIDs {
+		if err := revokeSessionTokens(sessionID); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit: %w", err)
+	}
+	return nil
+}
+
+// sessionBlacklistKey is the blacklist entry of a revoked session
+func sessionBlacklistKey(sessionID int) string {
+	return fmt.Sprintf("session:%d", sessionID)
+}
+
+// revokeSessionTokens blacklists the access tokens of a session until the
+// longest of them expires
+func revokeSessionTokens(sessionID int) error {
+	if err := BlacklistToken(sessionBlacklistKey(sessionID), accessTokenDuration); err != nil {
+		log.Printf("⚠️ Failed to blacklist session %d: %v", sessionID, err)
+		return fmt.Errorf("failed to blacklist session %d: %w", sessionID, err)
+	}
+	return nil
+}
+
+// IsSessionRevoked reports whether the session of an access token was
+// revoked. ValidateToken already rejects tokens without a session.
+func IsSessionRevoked(sessionID int) bool {
+	return IsTokenBlacklisted(sessionBlacklistKey(sessionID))
+}
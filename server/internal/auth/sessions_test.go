@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const (
+	testIP        = "203.0.113.7"
+	testUserAgent = "test-agent"
+)
+
+// capturedArg matches any argument and remembers it
+type capturedArg struct {
+	value driver.Value
+}
+
+func (a *capturedArg) Match(v driver.Value) bool {
+	a.value = v
+	return true
+}
+
+func newMockService(t *testing.T) (*AuthService, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+	return NewAuthService(db), mock
+}
+
+func expectSessionLookup(mock sqlmock.Sqlmock, refreshToken string) *sqlmock.ExpectedQuery {
+	return mock.ExpectQuery(regexp.QuoteMeta("FROM user_sessions")).
+		WithArgs(hashRefreshToken(refreshToken))
+}
+
+func TestRotateRefreshToken(t *testing.T) {
+	service, mock := newMockService(t)
+	newHash := &capturedArg{}
+
+	mock.ExpectBegin()
+	expectSessionLookup(mock, "old-token").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "expired"}).AddRow(7, 42, false))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO revoked_refresh_tokens")).
+		WithArgs(hashRefreshToken("old-token"), 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE user_sessions")).
+		WithArgs(7, newHash, testIP, testUserAgent, refreshTokenDuration.Seconds()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(regexp.QuoteMeta("FROM users")).
+		WithArgs(42).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "avatar_url", "is_online", "last_seen_at", "created_at"}).
+			AddRow(42, "alice", "alice@example.com", "", false, nil, time.Now()))
+
+	user, sessionID, newToken, err := service.RotateRefreshToken("old-token", testIP, testUserAgent)
+	if err != nil {
+		t.Fatalf("RotateRefreshToken() error = %v", err)
+	}
+	if user.ID != 42 || sessionID != 7 {
+		t.Errorf("got user %d, session %d, want user 42, session 7", user.ID, sessionID)
+	}
+	if newToken == "" || newToken == "old-token" {
+		t.Errorf("refresh token was not rotated: %q", newToken)
+	}
+	if newHash.value != hashRefreshToken(newToken) {
+		t.Errorf("stored hash %v doesn't match the returned token", newHash.value)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRotateRefreshTokenReuseRevokesSession(t *testing.T) {
+	service, mock := newMockService(t)
+
+	mock.ExpectBegin()
+	expectSessionLookup(mock, "rotated-token").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "expired"}))
+	mock.ExpectQuery(regexp.QuoteMeta("FROM revoked_refresh_tokens")).
+		WithArgs(hashRefreshToken("rotated-token")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow(8, 42))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM user_sessions WHERE id = $1")).
+		WithArgs(8).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	user, sessionID, newToken, err := service.RotateRefreshToken("rotated-token", testIP, testUserAgent)
+	if !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("RotateRefreshToken() error = %v, want %v", err, ErrRefreshTokenReused)
+	}
+	if user == nil || user.ID != 42 || sessionID != 8 {
+		t.Errorf("got user %v, session %d, want user 42, session 8", user, sessionID)
+	}
+	if newToken != "" {
+		t.Errorf("a reused token must not be rotated, got %q", newToken)
+	}
+	if !IsSessionRevoked(8) {
+		t.Error("access tokens of the session are still accepted")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRotateRefreshTokenExpired(t *testing.T) {
+	service, mock := newMockService(t)
+
+	mock.ExpectBegin()
+	expectSessionLookup(mock, "expired-token").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "expired"}).AddRow(9, 42, true))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM user_sessions WHERE id = $1")).
+		WithArgs(9).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	_, _, newToken, err := service.RotateRefreshToken("expired-token", testIP, testUserAgent)
+	if !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("RotateRefreshToken() error = %v, want %v", err, ErrInvalidRefreshToken)
+	}
+	if newToken != "" {
+		t.Errorf("an expired token must not be rotated, got %q", newToken)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRotateRefreshTokenUnknown(t *testing.T) {
+	service, mock := newMockService(t)
+
+	mock.ExpectBegin()
+	expectSessionLookup(mock, "unknown-token").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "expired"}))
+	mock.ExpectQuery(regexp.QuoteMeta("FROM revoked_refresh_tokens")).
+		WithArgs(hashRefreshToken("unknown-token")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}))
+	mock.ExpectRollback()
+
+	_, _, _, err := service.RotateRefreshToken("unknown-token", testIP, testUserAgent)
+	if !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("RotateRefreshToken() error = %v, want %v", err, ErrInvalidRefreshToken)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidateTokenRequiresSession(t *testing.T) {
+	user := &User{ID: 42, Email: "alice@example.com", Username: "alice"}
+
+	legacy, err := GenerateToken(user, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateToken(legacy); !errors.Is(err, ErrTokenWithoutSession) {
+		t.Errorf("ValidateToken() of a token without session: error = %v, want %v", err, ErrTokenWithoutSession)
+	}
+
+	current, err := GenerateToken(user, 7, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ValidateToken(current)
+	if err != nil {
+		t.Fatalf("ValidateToken() error = %v", err)
+	}
+	if claims.SessionID != 7 {
+		t.Errorf("SessionID = %d, want 7", claims.SessionID)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	if err := SetTrustedProxies("10.0.0.0/8, 192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { trustedProxies = nil })
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{
+			name:       "direct client",
+			remoteAddr: testIP + ":5000",
+			want:       testIP,
+		},
+		{
+			name:       "untrusted peer can't forge X-Forwarded-For",
+			remoteAddr: testIP + ":5000",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			want:       testIP,
+		},
+		{
+			name:       "untrusted peer can't forge X-Real-IP",
+			remoteAddr: testIP + ":5000",
+			headers:    map[string]string{"X-Real-IP": "198.51.100.1"},
+			want:       testIP,
+		},
+		{
+			name:       "trusted proxy reports X-Real-IP",
+			remoteAddr: "10.1.2.3:5000",
+			headers:    map[string]string{"X-Real-IP": testIP},
+			want:       testIP,
+		},
+		{
+			name:       "trusted proxy appends the client to X-Forwarded-For",
+			remoteAddr: "192.0.2.1:5000",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1, " + testIP + ", 10.0.0.9"},
+			want:       testIP,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/auth/refresh", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for key, value := range tt.headers {
+				r.Header.Set(key, value)
+			}
+			if got := clientIP(r); got != tt.want {
+				t.Errorf("clientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package cache
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ==================== PRESENCE RECONCILIATION ====================
+
+// RegisterInstance marks a server instance as alive, must be called more
+// often than TTLInstance
+func (c *CacheService) RegisterInstance(instanceID string) error {
+	if err := c.redis.SAdd(KeyInstances, instanceID); err != nil {
+		return err
+	}
+	return c.redis.Set(PrefixInstanceAlive+instanceID, "1", TTLInstance)
+}
+
+// GetDeadInstances returns the known instances that stopped refreshing their liveness key
+func (c *CacheService) GetDeadInstances() ([]string, error) {
+	instances, err := c.redis.SMembers(KeyInstances)
+	if err != nil {
+		return nil, err
+	}
+
+	var dead []string
+	for _, instanceID := range instances {
+		alive, err := c.redis.Exists(PrefixInstanceAlive + instanceID)
+		if err != nil {
+			return nil, err
+		}
+		if !alive {
+			dead = append(dead, instanceID)
+		}
+	}
+	return dead, nil
+}
+
+// ClaimDeadInstance forgets a dead instance. Only one caller gets true, so
+// only one instance cleans up after it.
+func (c *CacheService) ClaimDeadInstance(instanceID string) (bool, error) {
+	n, err := c.redis.SRemCount(KeyInstances, instanceID)
+	return n > 0, err
+}
+
+// RemoveInstanceConnections removes every connection recorded by an instance
+// and returns the users left without any connection
+func (c *CacheService) RemoveInstanceConnections(instanceID string) ([]int, error) {
+	usersKey := PrefixInstanceUsers + instanceID
+	members, err := c.redis.SMembers(usersKey)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := connectionField(instanceID, "")
+	var disconnected []int
+	for _, member := range members {
+		userID, err := strconv.Atoi(member)
+		if err != nil {
+			continue
+		}
+
+		key := fmt.Sprintf("%s%d", PrefixUserConnections, userID)
+		fields, err := c.redis.HGetAll(key)
+		if err != nil {
+			return nil, err
+		}
+		var stale []string
+		for field := range fields {
+			if strings.HasPrefix(field, prefix) {
+				stale = append(stale, field)
+			}
+		}
+		if len(stale) == 0 {
+			continue
+		}
+		if err := c.redis.HDel(key, stale...); err != nil {
+			return nil, err
+		}
+		if len(stale) == len(fields) {
+			disconnected = append(disconnected, userID)
+		}
+	}
+
+	return disconnected, c.redis.Delete(usersKey)
+}
+
+// GetStaleOnlineUsers returns the users of the online set whose online flag
+// expired, i.e. no instance refreshed it for TTLOnlineStatus
+func (c *CacheService) GetStaleOnlineUsers() ([]int, error) {
+	members, err := c.redis.SMembers(KeyOnlineUsers)
+	if err != nil {
+		return nil, err
+	}
+
+	var stale []int
+	for _, member := range members {
+		userID, err := strconv.Atoi(member)
+		if err != nil {
+			continue
+		}
+		online, err := c.redis.Exists(fmt.Sprintf("%s%d", PrefixUserOnline, userID))
+		if err != nil {
+			return nil, err
+		}
+		if !online {
+			stale = append(stale, userID)
+		}
+	}
+	return stale, nil
+}
+
+// RestoreUserOnline keeps a user with a live connection online and reports
+// whether they had to be added back to the online set, e.g. after a
+// reconciler claimed them while their connection was late refreshing
+func (c *CacheService) RestoreUserOnline(userID int) (bool, error) {
+	added, err := c.redis.SAddCount(KeyOnlineUsers, userID)
+	if err != nil {
+		return false, err
+	}
+	key := fmt.Sprintf("%s%d", PrefixUserOnline, userID)
+	return added > 0, c.redis.Set(key, "1", TTLOnlineStatus)
+}
+
+// ClaimStaleUser removes a stale user from the online set along with their
+// connections recorded by dead instances. Users still holding a connection on
+// a live instance are left alone: that instance refreshes them on its next
+// reconcile. Only one caller gets true, so the user is announced offline once.
+func (c *CacheService) ClaimStaleUser(userID int) (bool, error) {
+	key := fmt.Sprintf("%s%d", PrefixUserConnections, userID)
+	fields, err := c.redis.HGetAll(key)
+	if err != nil {
+		return false, err
+	}
+
+	var dead []string
+	alive := make(map[string]bool)
+	for field := range fields {
+		instanceID, _, _ := strings.Cut(field, "|")
+		isAlive, checked := alive[instanceID]
+		if !checked {
+			if isAlive, err = c.redis.Exists(PrefixInstanceAlive + instanceID); err != nil {
+				return false, err
+			}
+			alive[instanceID] = isAlive
+		}
+		if isAlive {
+			return false, nil
+		}
+		dead = append(dead, field)
+	}
+
+	n, err := c.redis.SRemCount(KeyOnlineUsers, userID)
+	if err != nil || n == 0 {
+		return false, err
+	}
+	if len(dead) > 0 {
+		if err := c.redis.HDel(key, dead...); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
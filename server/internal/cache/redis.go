@@ -98,11 +98,21 @@ func (r *RedisClient) SAdd(key string, members ...interface{}) error {
 	return r.client.SAdd(r.ctx, key, members...).Err()
 }
 
+// SAddCount adds members to a set and returns how many were not in it yet
+func (r *RedisClient) SAddCount(key string, members ...interface{}) (int64, error) {
+	return r.client.SAdd(r.ctx, key, members...).Result()
+}
+
 // SRem removes members from a set
 func (r *RedisClient) SRem(key string, members ...interface{}) error {
 	return r.client.SRem(r.ctx, key, members...).Err()
 }
 
+// SRemCount removes members from a set and returns how many were removed
+func (r *RedisClient) SRemCount(key string, members ...interface{}) (int64, error) {
+	return r.client.SRem(r.ctx, key, members...).Result()
+}
+
 // SMembers gets all members of a set
 func (r *RedisClient) SMembers(key string) ([]string, error) {
 	return r.client.SMembers(r.ctx, key).Result()
@@ -139,6 +149,34 @@ func (r *RedisClient) HDel(key string, fields ...string) error {
 	return r.client.HDel(r.ctx, key, fields...).Err()
 }
 
+// HSetLen sets field in hash and returns the number of fields, atomically
+func (r *RedisClient) HSetLen(key, field string, value interface{}) (int64, error) {
+	var n *redis.IntCmd
+	_, err := r.client.TxPipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(r.ctx, key, field, value)
+		n = pipe.HLen(r.ctx, key)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n.Val(), nil
+}
+
+// HDelLen deletes a field from hash and returns the number of fields left, atomically
+func (r *RedisClient) HDelLen(key, field string) (int64, error) {
+	var n *redis.IntCmd
+	_, err := r.client.TxPipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		pipe.HDel(r.ctx, key, field)
+		n = pipe.HLen(r.ctx, key)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n.Val(), nil
+}
+
 // ==================== LIST OPERATIONS ====================
 
 // LPush prepends values to a list
@@ -156,6 +194,30 @@ func (r *RedisClient) LTrim(key string, start, stop int64) error {
 	return r.client.LTrim(r.ctx, key, start, stop).Err()
 }
 
+// ==================== COUNTER OPERATIONS ====================
+
+// Incr increments the integer value of a key by one
+func (r *RedisClient) Incr(key string) (int64, error) {
+	return r.client.Incr(r.ctx, key).Result()
+}
+
+// ==================== SORTED SET OPERATIONS ====================
+
+// ZAdd adds a member with a score to a sorted set
+func (r *RedisClient) ZAdd(key string, score float64, member interface{}) error {
+	return r.client.ZAdd(r.ctx, key, redis.Z{Score: score, Member: member}).Err()
+}
+
+// ZRangeByScore gets members with scores between min and max (use "(" for exclusive bounds)
+func (r *RedisClient) ZRangeByScore(key, min, max string) ([]string, error) {
+	return r.client.ZRangeByScore(r.ctx, key, &redis.ZRangeBy{Min: min, Max: max}).Result()
+}
+
+// ZRemRangeByRank removes members in the given rank range from a sorted set
+func (r *RedisClient) ZRemRangeByRank(key string, start, stop int64) error {
+	return r.client.ZRemRangeByRank(r.ctx, key, start, stop).Err()
+}
+
 // ==================== EXPIRATION ====================
 
 // Expire sets a timeout on a key
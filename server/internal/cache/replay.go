@@ -0,0 +1,50 @@
+package cache
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// MaxReplayFrames is how many recent frames are kept per user for resuming
+const MaxReplayFrames = 200
+
+// ==================== WEBSOCKET SESSION REPLAY ====================
+
+// NextUserSeq returns the next sequence number for frames sent to a user.
+// The counter never expires so sequence numbers stay monotonic.
+func (c *CacheService) NextUserSeq(userID int) (int64, error) {
+	key := fmt.Sprintf("%s%d", PrefixUserSeq, userID)
+	return c.redis.Incr(key)
+}
+
+// GetUserSeq returns the last sequence number assigned to a user (0 if none)
+func (c *CacheService) GetUserSeq(userID int) (int64, error) {
+	key := fmt.Sprintf("%s%d", PrefixUserSeq, userID)
+	exists, err := c.redis.Exists(key)
+	if err != nil || !exists {
+		return 0, err
+	}
+	val, err := c.redis.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(val, 10, 64)
+}
+
+// AppendReplayFrame stores a sequenced frame, keeping only the newest MaxReplayFrames
+func (c *CacheService) AppendReplayFrame(userID int, seq int64, frame []byte) error {
+	key := fmt.Sprintf("%s%d", PrefixReplayFrames, userID)
+	if err := c.redis.ZAdd(key, float64(seq), string(frame)); err != nil {
+		return err
+	}
+	if err := c.redis.ZRemRangeByRank(key, 0, -MaxReplayFrames-1); err != nil {
+		return err
+	}
+	return c.redis.Expire(key, TTLReplayFrames)
+}
+
+// GetReplayFrames returns stored frames with a sequence number greater than afterSeq, oldest first
+func (c *CacheService) GetReplayFrames(userID int, afterSeq int64) ([]string, error) {
+	key := fmt.Sprintf("%s%d", PrefixReplayFrames, userID)
+	return c.redis.ZRangeByScore(key, fmt.Sprintf("(%d", afterSeq), "+inf")
+}
@@ -1,7 +1,9 @@
 package cache
 
 import (
+	"encoding/json"
 	"fmt"
+	"sort"
 	"time"
 )
 
@@ -14,14 +16,27 @@ const (
 	// User data cache
 	PrefixUserProfile = "user:profile:"
 	PrefixUserFriends = "user:friends:"
+	PrefixSuggestions = "user:suggestions:"
 	PrefixUserOnline  = "user:online:"
 
+	// Live WebSocket connections of a user, field "<instance>|<conn id>"
+	PrefixUserConnections = "ws:conns:user:"
+
+	// Server instances (keyed by HOSTNAME) and the users connected to each
+	KeyInstances        = "ws:instances"
+	PrefixInstanceAlive = "ws:instance:alive:"
+	PrefixInstanceUsers = "ws:instance:users:"
+
 	// Online users set
 	KeyOnlineUsers = "online:users"
 
 	// Message cache
 	PrefixConversation   = "conversation:"
 	PrefixMessageHistory = "messages:history:"
+
+	// WebSocket session replay
+	PrefixUserSeq      = "ws:seq:user:"
+	PrefixReplayFrames = "ws:replay:user:"
 )
 
 // Cache TTL durations
@@ -30,8 +45,11 @@ const (
 	TTLSession      = 24 * time.Hour   // User session
 	TTLUserProfile  = 1 * time.Hour    // User profile
 	TTLFriendsList  = 5 * time.Minute  // Friends list
+	TTLSuggestions  = 10 * time.Minute // Friend suggestions
 	TTLOnlineStatus = 30 * time.Second // Online status
+	TTLInstance     = 30 * time.Second // Instance liveness
 	TTLMessages     = 10 * time.Minute // Message history
+	TTLReplayFrames = 1 * time.Hour    // Frames kept for resuming WebSocket sessions
 )
 
 // CacheService provides high-level caching operations
@@ -94,12 +112,26 @@ func (c *CacheService) DeleteUserSession(userID int) error {
 
 // ==================== USER PROFILE ====================
 
-// UserProfile represents cached user profile
+// UserProfile represents cached user profile. Only public fields are cached.
 type UserProfile struct {
 	ID        int    `json:"id"`
 	Username  string `json:"username"`
-	Email     string `json:"email"`
 	AvatarURL string `json:"avatar_url"`
+
+	// Custom status
+	StatusText      string     `json:"status_text,omitempty"`
+	StatusEmoji     string     `json:"status_emoji,omitempty"`
+	StatusExpiresAt *time.Time `json:"status_expires_at,omitempty"`
+}
+
+// ClearExpiredStatus empties the custom status once it expired, a cached
+// profile may outlive its status
+func (p *UserProfile) ClearExpiredStatus() {
+	if p.StatusExpiresAt != nil && !p.StatusExpiresAt.After(time.Now()) {
+		p.StatusText = ""
+		p.StatusEmoji = ""
+		p.StatusExpiresAt = nil
+	}
 }
 
 // SetUserProfile caches user profile
@@ -116,6 +148,7 @@ func (c *CacheService) GetUserProfile(userID int) (*UserProfile, error) {
 	if err != nil {
 		return nil, err
 	}
+	profile.ClearExpiredStatus()
 	return &profile, nil
 }
 
@@ -153,9 +186,54 @@ func (c *CacheService) GetFriendsList(userID int) ([]Friend, error) {
 	return friends, nil
 }
 
-// InvalidateFriendsList removes friends list from cache
+// InvalidateFriendsList removes friends list and friend suggestions from cache
 func (c *CacheService) InvalidateFriendsList(userID int) error {
 	key := fmt.Sprintf("%s%d", PrefixUserFriends, userID)
+	if err := c.redis.Delete(key); err != nil {
+		return err
+	}
+	return c.InvalidateFriendSuggestions(userID)
+}
+
+// ==================== FRIEND SUGGESTIONS ====================
+
+// MutualFriend is a friend shared with a suggested user
+type MutualFriend struct {
+	ID        int    `json:"id"`
+	Username  string `json:"username"`
+	AvatarURL string `json:"avatar_url"`
+}
+
+// FriendSuggestion represents a cached friend suggestion
+type FriendSuggestion struct {
+	ID            int            `json:"id"`
+	Username      string         `json:"username"`
+	AvatarURL     string         `json:"avatar_url"`
+	MutualCount   int            `json:"mutual_count"`
+	SharedRooms   int            `json:"shared_rooms"`
+	MutualFriends []MutualFriend `json:"mutual_friends"` // preview, not the full list
+}
+
+// SetFriendSuggestions caches user's friend suggestions
+func (c *CacheService) SetFriendSuggestions(userID int, suggestions []FriendSuggestion) error {
+	key := fmt.Sprintf("%s%d", PrefixSuggestions, userID)
+	return c.redis.SetJSON(key, suggestions, TTLSuggestions)
+}
+
+// GetFriendSuggestions retrieves cached friend suggestions
+func (c *CacheService) GetFriendSuggestions(userID int) ([]FriendSuggestion, error) {
+	key := fmt.Sprintf("%s%d", PrefixSuggestions, userID)
+	var suggestions []FriendSuggestion
+	err := c.redis.GetJSON(key, &suggestions)
+	if err != nil {
+		return nil, err
+	}
+	return suggestions, nil
+}
+
+// InvalidateFriendSuggestions removes friend suggestions from cache
+func (c *CacheService) InvalidateFriendSuggestions(userID int) error {
+	key := fmt.Sprintf("%s%d", PrefixSuggestions, userID)
 	return c.redis.Delete(key)
 }
 
@@ -195,10 +273,69 @@ func (c *CacheService) GetOnlineUsers() ([]string, error) {
 	return c.redis.SMembers(KeyOnlineUsers)
 }
 
-// RefreshUserOnline refreshes user's online status (called on heartbeat)
+// DeviceConnection is one live WebSocket connection of a user
+type DeviceConnection struct {
+	ConnID      string    `json:"conn_id"`
+	Instance    string    `json:"instance"`
+	Device      string    `json:"device"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+// connectionField is the hash field of a connection, unique across instances
+func connectionField(instance, connID string) string {
+	return instance + "|" + connID
+}
+
+// AddUserConnection records a live connection of a user and returns how many
+// connections the user now has across all instances
+func (c *CacheService) AddUserConnection(userID int, conn *DeviceConnection) (int64, error) {
+	data, err := json.Marshal(conn)
+	if err != nil {
+		return 0, err
+	}
+	// Lets the connection be cleaned up if its instance crashes
+	if err := c.redis.SAdd(PrefixInstanceUsers+conn.Instance, userID); err != nil {
+		return 0, err
+	}
+	key := fmt.Sprintf("%s%d", PrefixUserConnections, userID)
+	return c.redis.HSetLen(key, connectionField(conn.Instance, conn.ConnID), data)
+}
+
+// RemoveUserConnection forgets a closed connection and returns how many
+// connections the user still has across all instances
+func (c *CacheService) RemoveUserConnection(userID int, instance, connID string) (int64, error) {
+	key := fmt.Sprintf("%s%d", PrefixUserConnections, userID)
+	return c.redis.HDelLen(key, connectionField(instance, connID))
+}
+
+// GetUserConnections returns the live connections of a user, oldest first
+func (c *CacheService) GetUserConnections(userID int) ([]DeviceConnection, error) {
+	key := fmt.Sprintf("%s%d", PrefixUserConnections, userID)
+	fields, err := c.redis.HGetAll(key)
+	if err != nil {
+		return nil, err
+	}
+
+	conns := make([]DeviceConnection, 0, len(fields))
+	for _, value := range fields {
+		var conn DeviceConnection
+		if err := json.Unmarshal([]byte(value), &conn); err != nil {
+			continue // skip corrupted entries
+		}
+		conns = append(conns, conn)
+	}
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].ConnectedAt.Before(conns[j].ConnectedAt)
+	})
+	return conns, nil
+}
+
+// RefreshUserOnline refreshes user's online status (called on heartbeat).
+// The flag is set again rather than extended, so a heartbeat arriving after
+// it expired still brings it back.
 func (c *CacheService) RefreshUserOnline(userID int) error {
 	key := fmt.Sprintf("%s%d", PrefixUserOnline, userID)
-	return c.redis.Expire(key, TTLOnlineStatus)
+	return c.redis.Set(key, "1", TTLOnlineStatus)
 }
 
 // ==================== MESSAGE HISTORY ====================
@@ -252,6 +389,7 @@ func (c *CacheService) ClearUserCache(userID int) error {
 		fmt.Sprintf("%s%d", PrefixUserSession, userID),
 		fmt.Sprintf("%s%d", PrefixUserProfile, userID),
 		fmt.Sprintf("%s%d", PrefixUserFriends, userID),
+		fmt.Sprintf("%s%d", PrefixSuggestions, userID),
 		fmt.Sprintf("%s%d", PrefixUserOnline, userID),
 	}
 
@@ -0,0 +1,26 @@
+package database
+
+import (
+	"fmt"
+)
+
+// IsBlocked reports whether either user blocked the other
+func (db *DB) IsBlocked(userID1, userID2 int) (bool, error) {
+	var blocked bool
+	err := db.conn.QueryRow(`SELECT is_blocked($1, $2)`, userID1, userID2).Scan(&blocked)
+	if err != nil {
+		return false, fmt.Errorf("failed to check block: %w", err)
+	}
+	return blocked, nil
+}
+
+// CanSendDirectMessage reports whether sender may write to receiver directly:
+// they are friends and neither blocked the other (same rule as the messages trigger)
+func (db *DB) CanSendDirectMessage(senderID, receiverID int) (bool, error) {
+	var allowed bool
+	err := db.conn.QueryRow(`SELECT can_send_direct_message($1, $2)`, senderID, receiverID).Scan(&allowed)
+	if err != nil {
+		return false, fmt.Errorf("failed to check direct message permission: %w", err)
+	}
+	return allowed, nil
+}
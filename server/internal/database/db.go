@@ -2,13 +2,27 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
+// Room errors
+var (
+	ErrRoomNotFound  = errors.New("room not found")
+	ErrRoomNameTaken = errors.New("room name already taken")
+)
+
+// ErrUserNotFound is returned when a user doesn't exist
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrNotFriends is returned when the check_direct_message_friendship trigger
+// rejects a direct message
+var ErrNotFriends = errors.New("users must be friends to send direct messages")
+
 // DB wraps the database connection
 type DB struct {
 	conn *sql.DB
@@ -24,20 +38,37 @@ type User struct {
 	IsOnline     bool       `json:"is_online"`
 	LastSeenAt   *time.Time `json:"last_seen_at,omitempty"`
 	CreatedAt    time.Time  `json:"created_at"`
+
+	// Custom status, only loaded by GetUserProfile
+	StatusText      string     `json:"status_text,omitempty"`
+	StatusEmoji     string     `json:"status_emoji,omitempty"`
+	StatusExpiresAt *time.Time `json:"status_expires_at,omitempty"`
 }
 
 // Message represents a chat message
 type Message struct {
-	ID           int       `json:"id"`
-	Type         string    `json:"type"`
-	FromUserID   int       `json:"from_user_id"`
-	FromUsername string    `json:"from_username,omitempty"`
-	ToUserID     *int      `json:"to_user_id,omitempty"`
-	RoomID       *int      `json:"room_id,omitempty"`
-	Text         string    `json:"text"`
-	Value        *float64  `json:"value,omitempty"`
-	IsRead       bool      `json:"is_read"`
-	CreatedAt    time.Time `json:"created_at"`
+	ID           int        `json:"id"`
+	Type         string     `json:"type"`
+	FromUserID   int        `json:"from_user_id"`
+	FromUsername string     `json:"from_username,omitempty"`
+	ToUserID     *int       `json:"to_user_id,omitempty"`
+	RoomID       *int       `json:"room_id,omitempty"`
+	Text         string     `json:"text"`
+	Value        *float64   `json:"value,omitempty"`
+	IsRead       bool       `json:"is_read"`
+	ReadAt       *time.Time `json:"read_at,omitempty"`
+	DeliveredAt  *time.Time `json:"delivered_at,omitempty"`
+	EditedAt     *time.Time `json:"edited_at,omitempty"`
+	DeletedAt    *time.Time `json:"deleted_at,omitempty"`     // deleted for everyone, Text is MessageTombstone
+	ClientMsgID  string     `json:"client_msg_id,omitempty"`  // sender-generated ID for idempotent retries
+	ReplyToID    *int       `json:"reply_to_id,omitempty"`    // quoted message
+	ThreadRootID *int       `json:"thread_root_id,omitempty"` // thread the message was posted in
+	ReplyCount   int        `json:"reply_count,omitempty"`    // replies in the thread rooted at this message
+	CreatedAt    time.Time  `json:"created_at"`
+
+	Reactions []*ReactionSummary `json:"reactions,omitempty"` // set by the history queries
+
+	Duplicate bool `json:"-"` // set by SaveMessage when the send was a retry
 }
 
 // Room represents a chat room
@@ -50,6 +81,13 @@ type Room struct {
 	CreatedAt   time.Time `json:"created_at"`
 }
 
+// RoomSummary is a room with membership information for listings
+type RoomSummary struct {
+	Room
+	MemberCount int    `json:"member_count"`
+	Role        string `json:"role,omitempty"` // caller's role, empty if not a member
+}
+
 // NewDB creates a new database connection
 func NewDB(host, port, user, password, dbname string) (*DB, error) {
 	connStr := fmt.Sprintf(
@@ -209,11 +247,24 @@ func (db *DB) UpdateUserOnlineStatus(userID int, isOnline bool) error {
 // Message Methods
 // ============================================
 
-// SaveMessage saves a new message to the database
+// MaxClientMsgIDLength matches messages.client_msg_id VARCHAR(64), which the
+// partial unique index (from_user_id, client_msg_id) uses to dedupe retried sends
+const MaxClientMsgIDLength = 64
+
+// SaveMessage saves a new message to the database. Reply references are
+// validated against the message's conversation (ErrInvalidReply).
+// When msg.ClientMsgID was already used by the same sender (a retried send),
+// nothing is inserted: msg is replaced by the original message and Duplicate is set.
 func (db *DB) SaveMessage(msg *Message) error {
+	if err := db.resolveThread(msg); err != nil {
+		return err
+	}
+
 	query := `
-		INSERT INTO messages (message_type, from_user_id, to_user_id, room_id, message_text, message_value)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		INSERT INTO messages (message_type, from_user_id, to_user_id, room_id, message_text, message_value, client_msg_id,
+		                      reply_to_id, thread_root_id)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8, $9)
+		ON CONFLICT (from_user_id, client_msg_id) WHERE client_msg_id IS NOT NULL DO NOTHING
 		RETURNING id, created_at
 	`
 
@@ -225,9 +276,27 @@ func (db *DB) SaveMessage(msg *Message) error {
 		msg.RoomID,
 		msg.Text,
 		msg.Value,
+		msg.ClientMsgID,
+		msg.ReplyToID,
+		msg.ThreadRootID,
 	).Scan(&msg.ID, &msg.CreatedAt)
 
+	if err == sql.ErrNoRows && msg.ClientMsgID != "" {
+		original, err := db.getMessageByClientMsgID(msg.FromUserID, msg.ClientMsgID)
+		if err != nil {
+			return err
+		}
+		*msg = *original
+		msg.Duplicate = true
+		log.Printf("♻️ Duplicate send ignored: client_msg_id=%s, original ID=%d", msg.ClientMsgID, msg.ID)
+		return nil
+	}
 	if err != nil {
+		// RAISE EXCEPTION in validate_direct_message() uses SQLSTATE P0001
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "P0001" && msg.ToUserID != nil {
+			return ErrNotFriends
+		}
 		return fmt.Errorf("failed to save message: %w", err)
 	}
 
@@ -235,103 +304,43 @@ func (db *DB) SaveMessage(msg *Message) error {
 	return nil
 }
 
-// GetMessageHistory retrieves message history for a room
-func (db *DB) GetMessageHistory(roomID int, limit int) ([]*Message, error) {
-	query := `
-		SELECT 
-			m.id, m.message_type, m.from_user_id, u.username, 
-			m.to_user_id, m.room_id, m.message_text, m.message_value, 
-			m.is_read, m.created_at
+// getMessageByClientMsgID retrieves a message by its sender and client-generated ID
+func (db *DB) getMessageByClientMsgID(fromUserID int, clientMsgID string) (*Message, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
 		FROM messages m
 		LEFT JOIN users u ON m.from_user_id = u.id
-		WHERE m.room_id = $1
-		ORDER BY m.created_at DESC
-		LIMIT $2
-	`
+		WHERE m.from_user_id = $1 AND m.client_msg_id = $2
+	`, messageSelectColumns)
 
-	rows, err := db.conn.Query(query, roomID, limit)
+	rows, err := db.conn.Query(query, fromUserID, clientMsgID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get message history: %w", err)
+		return nil, fmt.Errorf("failed to get message by client_msg_id: %w", err)
 	}
 	defer rows.Close()
 
-	var messages []*Message
-	for rows.Next() {
-		var msg Message
-		err := rows.Scan(
-			&msg.ID,
-			&msg.Type,
-			&msg.FromUserID,
-			&msg.FromUsername,
-			&msg.ToUserID,
-			&msg.RoomID,
-			&msg.Text,
-			&msg.Value,
-			&msg.IsRead,
-			&msg.CreatedAt,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan message: %w", err)
-		}
-		messages = append(messages, &msg)
+	if !rows.Next() {
+		return nil, fmt.Errorf("message with client_msg_id %s not found", clientMsgID)
 	}
+	return scanMessage(rows)
+}
 
-	// Reverse to get chronological order
-	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
-		messages[i], messages[j] = messages[j], messages[i]
+// GetMessageHistory retrieves the newest messages of a room
+func (db *DB) GetMessageHistory(roomID int, limit int) ([]*Message, error) {
+	page, err := db.GetRoomMessagePage(roomID, 0, HistoryCursor{Limit: limit})
+	if err != nil {
+		return nil, err
 	}
-
-	return messages, nil
+	return page.Messages, nil
 }
 
-// GetDirectMessageHistory retrieves direct message history between two users
+// GetDirectMessageHistory retrieves the newest direct messages between two users
 func (db *DB) GetDirectMessageHistory(userID1, userID2, limit int) ([]*Message, error) {
-	query := `
-		SELECT 
-			m.id, m.message_type, m.from_user_id, u.username,
-			m.to_user_id, m.room_id, m.message_text, m.message_value,
-			m.is_read, m.created_at
-		FROM messages m
-		LEFT JOIN users u ON m.from_user_id = u.id
-		WHERE (m.from_user_id = $1 AND m.to_user_id = $2)
-		   OR (m.from_user_id = $2 AND m.to_user_id = $1)
-		ORDER BY m.created_at DESC
-		LIMIT $3
-	`
-
-	rows, err := db.conn.Query(query, userID1, userID2, limit)
+	page, err := db.GetDirectMessagePage(userID1, userID2, HistoryCursor{Limit: limit})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get direct message history: %w", err)
-	}
-	defer rows.Close()
-
-	var messages []*Message
-	for rows.Next() {
-		var msg Message
-		err := rows.Scan(
-			&msg.ID,
-			&msg.Type,
-			&msg.FromUserID,
-			&msg.FromUsername,
-			&msg.ToUserID,
-			&msg.RoomID,
-			&msg.Text,
-			&msg.Value,
-			&msg.IsRead,
-			&msg.CreatedAt,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan message: %w", err)
-		}
-		messages = append(messages, &msg)
+		return nil, err
 	}
-
-	// Reverse to get chronological order
-	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
-		messages[i], messages[j] = messages[j], messages[i]
-	}
-
-	return messages, nil
+	return page.Messages, nil
 }
 
 // ============================================
@@ -341,7 +350,7 @@ func (db *DB) GetDirectMessageHistory(userID1, userID2, limit int) ([]*Message,
 // GetRoomByName retrieves a room by name
 func (db *DB) GetRoomByName(roomName string) (*Room, error) {
 	query := `
-		SELECT id, room_name, room_type, description, created_by, created_at
+		SELECT id, room_name, room_type, COALESCE(description, ''), created_by, created_at
 		FROM rooms
 		WHERE room_name = $1
 	`
@@ -366,10 +375,65 @@ func (db *DB) GetRoomByName(roomName string) (*Room, error) {
 	return &room, nil
 }
 
+// GetRoomByID retrieves a room by ID
+func (db *DB) GetRoomByID(roomID int) (*Room, error) {
+	query := `
+		SELECT id, room_name, room_type, COALESCE(description, ''), created_by, created_at
+		FROM rooms
+		WHERE id = $1
+	`
+
+	var room Room
+	err := db.conn.QueryRow(query, roomID).Scan(
+		&room.ID,
+		&room.RoomName,
+		&room.RoomType,
+		&room.Description,
+		&room.CreatedBy,
+		&room.CreatedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrRoomNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get room: %w", err)
+	}
+
+	return &room, nil
+}
+
+// IsRoomMember checks whether a user belongs to a room
+func (db *DB) IsRoomMember(roomID, userID int) (bool, error) {
+	var exists bool
+	err := db.conn.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM room_members WHERE room_id = $1 AND user_id = $2)`,
+		roomID, userID,
+	).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check room membership: %w", err)
+	}
+	return exists, nil
+}
+
+// AddRoomMember adds a user to a room with the given role (no-op if already a member)
+func (db *DB) AddRoomMember(roomID, userID int, role string) error {
+	query := `
+		INSERT INTO room_members (room_id, user_id, role)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (room_id, user_id) DO NOTHING
+	`
+
+	if _, err := db.conn.Exec(query, roomID, userID, role); err != nil {
+		return fmt.Errorf("failed to add room member: %w", err)
+	}
+	return nil
+}
+
 // GetAllRooms retrieves all public rooms
 func (db *DB) GetAllRooms() ([]*Room, error) {
 	query := `
-		SELECT id, room_name, room_type, description, created_by, created_at
+		SELECT id, room_name, room_type, COALESCE(description, ''), created_by, created_at
 		FROM rooms
 		WHERE room_type = 'public'
 		ORDER BY created_at ASC
@@ -401,6 +465,181 @@ func (db *DB) GetAllRooms() ([]*Room, error) {
 	return rooms, nil
 }
 
+// CreateRoom creates a room and adds its creator as admin
+func (db *DB) CreateRoom(roomName, roomType, description string, createdBy int) (*Room, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO rooms (room_name, room_type, description, created_by)
+		VALUES ($1, $2, NULLIF($3, ''), $4)
+		ON CONFLICT (room_name) DO NOTHING
+		RETURNING id, room_name, room_type, COALESCE(description, ''), created_by, created_at
+	`
+
+	var room Room
+	err = tx.QueryRow(query, roomName, roomType, description, createdBy).Scan(
+		&room.ID,
+		&room.RoomName,
+		&room.RoomType,
+		&room.Description,
+		&room.CreatedBy,
+		&room.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrRoomNameTaken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create room: %w", err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO room_members (room_id, user_id, role)
+		VALUES ($1, $2, 'admin')
+	`, room.ID, createdBy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add room creator: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit room: %w", err)
+	}
+
+	log.Printf("🏠 Room created: ID=%d, Name=%s, Type=%s, By=%d", room.ID, room.RoomName, room.RoomType, createdBy)
+	return &room, nil
+}
+
+// UpdateRoom updates a room's name, type and description
+func (db *DB) UpdateRoom(roomID int, roomName, roomType, description string) (*Room, error) {
+	if existing, err := db.GetRoomByName(roomName); err == nil && existing.ID != roomID {
+		return nil, ErrRoomNameTaken
+	}
+
+	query := `
+		UPDATE rooms
+		SET room_name = $2, room_type = $3, description = NULLIF($4, '')
+		WHERE id = $1
+		RETURNING id, room_name, room_type, COALESCE(description, ''), created_by, created_at
+	`
+
+	var room Room
+	err := db.conn.QueryRow(query, roomID, roomName, roomType, description).Scan(
+		&room.ID,
+		&room.RoomName,
+		&room.RoomType,
+		&room.Description,
+		&room.CreatedBy,
+		&room.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrRoomNotFound
+	}
+	if err != nil {
+		// A concurrent rename took the name after the check above
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return nil, ErrRoomNameTaken
+		}
+		return nil, fmt.Errorf("failed to update room: %w", err)
+	}
+
+	return &room, nil
+}
+
+// DeleteRoom deletes a room (members and messages are removed by cascade)
+func (db *DB) DeleteRoom(roomID int) error {
+	result, err := db.conn.Exec(`DELETE FROM rooms WHERE id = $1`, roomID)
+	if err != nil {
+		return fmt.Errorf("failed to delete room: %w", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return ErrRoomNotFound
+	}
+	return nil
+}
+
+// GetRoomMemberRole returns a user's role in a room, or "" if not a member
+func (db *DB) GetRoomMemberRole(roomID, userID int) (string, error) {
+	var role string
+	err := db.conn.QueryRow(
+		`SELECT COALESCE(role, 'member') FROM room_members WHERE room_id = $1 AND user_id = $2`,
+		roomID, userID,
+	).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get room role: %w", err)
+	}
+	return role, nil
+}
+
+// GetUserRooms retrieves all rooms a user belongs to, with member counts
+func (db *DB) GetUserRooms(userID int) ([]*RoomSummary, error) {
+	query := `
+		SELECT r.id, r.room_name, r.room_type, COALESCE(r.description, ''), r.created_by, r.created_at,
+		       (SELECT COUNT(*) FROM room_members c WHERE c.room_id = r.id) AS member_count,
+		       COALESCE(rm.role, 'member')
+		FROM rooms r
+		INNER JOIN room_members rm ON rm.room_id = r.id AND rm.user_id = $1
+		ORDER BY r.room_name ASC
+	`
+
+	return db.queryRoomSummaries(query, userID)
+}
+
+// GetRoomDirectory retrieves public rooms matching an optional name filter, with member counts
+func (db *DB) GetRoomDirectory(userID int, search string, limit, offset int) ([]*RoomSummary, error) {
+	query := `
+		SELECT r.id, r.room_name, r.room_type, COALESCE(r.description, ''), r.created_by, r.created_at,
+		       (SELECT COUNT(*) FROM room_members c WHERE c.room_id = r.id) AS member_count,
+		       COALESCE(rm.role, '')
+		FROM rooms r
+		LEFT JOIN room_members rm ON rm.room_id = r.id AND rm.user_id = $1
+		WHERE r.room_type = 'public'
+		  AND ($2 = '' OR LOWER(r.room_name) LIKE '%' || LOWER($2) || '%')
+		ORDER BY member_count DESC, r.room_name ASC
+		LIMIT $3 OFFSET $4
+	`
+
+	return db.queryRoomSummaries(query, userID, EscapeLike(search), limit, offset)
+}
+
+// queryRoomSummaries runs a room listing query and scans the rows
+func (db *DB) queryRoomSummaries(query string, args ...interface{}) ([]*RoomSummary, error) {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rooms: %w", err)
+	}
+	defer rows.Close()
+
+	var rooms []*RoomSummary
+	for rows.Next() {
+		var room RoomSummary
+		err := rows.Scan(
+			&room.ID,
+			&room.RoomName,
+			&room.RoomType,
+			&room.Description,
+			&room.CreatedBy,
+			&room.CreatedAt,
+			&room.MemberCount,
+			&room.Role,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan room: %w", err)
+		}
+		rooms = append(rooms, &room)
+	}
+
+	return rooms, rows.Err()
+}
+
 // ============================================
 // Friend Methods
 // ============================================
@@ -472,12 +711,17 @@ func (db *DB) GetUserConversations(userID int) ([]*Conversation, error) {
 				ELSE from_user_id
 			END as other_user_id,
 			message_text,
+			deleted_at IS NOT NULL as is_deleted,
 			created_at,
 			is_read,
 			from_user_id
-			FROM messages
+			FROM messages m
 			WHERE (from_user_id = $1 OR to_user_id = $1)
 			  AND to_user_id IS NOT NULL
+			  AND NOT EXISTS (
+				SELECT 1 FROM message_hidden h
+				WHERE h.message_id = m.id AND h.user_id = $1
+			  )
 			ORDER BY 
 				CASE 
 					WHEN from_user_id = $1 THEN to_user_id
@@ -489,15 +733,17 @@ func (db *DB) GetUserConversations(userID int) ([]*Conversation, error) {
 			u.id,
 			u.username,
 			COALESCE(u.avatar_url, '') as avatar_url,
-			u.is_online,
+			u.is_online AND u.presence_mode != 'invisible',
 			rm.message_text,
+			rm.is_deleted,
 			rm.created_at,
 			COALESCE(
 				(SELECT COUNT(*) 
 				 FROM messages 
 				 WHERE from_user_id = u.id 
 				   AND to_user_id = $1 
-				   AND is_read = false),
+				   AND is_read = false
+				   AND deleted_at IS NULL),
 				0
 			) as unread_count
 		FROM recent_messages rm
@@ -514,18 +760,23 @@ func (db *DB) GetUserConversations(userID int) ([]*Conversation, error) {
 	var conversations []*Conversation
 	for rows.Next() {
 		var conv Conversation
+		var lastDeleted bool
 		err := rows.Scan(
 			&conv.UserID,
 			&conv.Username,
 			&conv.AvatarURL,
 			&conv.IsOnline,
 			&conv.LastMessage,
+			&lastDeleted,
 			&conv.LastMessageAt,
 			&conv.UnreadCount,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan conversation: %w", err)
 		}
+		if lastDeleted {
+			conv.LastMessage = MessageTombstone
+		}
 		conversations = append(conversations, &conv)
 	}
 
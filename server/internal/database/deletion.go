@@ -0,0 +1,85 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// MessageTombstone replaces the text of messages deleted for everyone
+const MessageTombstone = "This message was deleted"
+
+// Message deletion errors
+var (
+	ErrMessageDeleted      = errors.New("message has been deleted")
+	ErrCannotDeleteMessage = errors.New("only the author or a room moderator can delete this message for everyone")
+)
+
+// DeleteMessage retracts a message for everyone. The author can delete their
+// own messages, room moderators and admins any message of their room.
+// The original text is kept, queries return MessageTombstone instead.
+func (db *DB) DeleteMessage(messageID, actorID int) (*Message, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var authorID int
+	var roomID sql.NullInt64
+	var deleted bool
+	err = tx.QueryRow(`
+		SELECT from_user_id, room_id, deleted_at IS NOT NULL
+		FROM messages
+		WHERE id = $1
+		FOR UPDATE
+	`, messageID).Scan(&authorID, &roomID, &deleted)
+	if err == sql.ErrNoRows {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message: %w", err)
+	}
+
+	if deleted {
+		return nil, ErrMessageDeleted
+	}
+	if authorID != actorID {
+		if !roomID.Valid {
+			return nil, ErrCannotDeleteMessage
+		}
+		role, err := db.GetRoomMemberRole(int(roomID.Int64), actorID)
+		if err != nil {
+			return nil, err
+		}
+		if RoomRoleRank(role) < RoomRoleRank("moderator") {
+			return nil, ErrCannotDeleteMessage
+		}
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE messages SET deleted_at = CURRENT_TIMESTAMP, deleted_by = $2
+		WHERE id = $1
+	`, messageID, actorID); err != nil {
+		return nil, fmt.Errorf("failed to delete message: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit deletion: %w", err)
+	}
+
+	return db.GetMessageByID(messageID)
+}
+
+// HideMessage deletes a message for one user only ("delete for me")
+func (db *DB) HideMessage(messageID, userID int) error {
+	_, err := db.conn.Exec(`
+		INSERT INTO message_hidden (message_id, user_id)
+		VALUES ($1, $2)
+		ON CONFLICT (message_id, user_id) DO NOTHING
+	`, messageID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to hide message: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// GetUndeliveredMessages retrieves direct messages still waiting to be
+// delivered to a user, oldest first
+func (db *DB) GetUndeliveredMessages(userID, limit int) ([]*Message, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM messages m
+		LEFT JOIN users u ON m.from_user_id = u.id
+		WHERE m.to_user_id = $1 AND m.delivered_at IS NULL AND m.deleted_at IS NULL
+		ORDER BY m.id ASC
+		LIMIT $2
+	`, messageSelectColumns)
+
+	rows, err := db.conn.Query(query, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get undelivered messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []*Message
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// MarkMessagesDelivered marks the given pending direct messages of a user as delivered
+func (db *DB) MarkMessagesDelivered(userID int, messageIDs []int) error {
+	if len(messageIDs) == 0 {
+		return nil
+	}
+	_, err := db.conn.Exec(`
+		UPDATE messages SET delivered_at = CURRENT_TIMESTAMP
+		WHERE to_user_id = $1 AND id = ANY($2) AND delivered_at IS NULL
+	`, userID, pq.Array(messageIDs))
+	if err != nil {
+		return fmt.Errorf("failed to mark messages delivered: %w", err)
+	}
+	return nil
+}
+
+// MarkMessageDelivered marks a single direct message as delivered
+func (db *DB) MarkMessageDelivered(messageID int) error {
+	_, err := db.conn.Exec(`
+		UPDATE messages SET delivered_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND delivered_at IS NULL
+	`, messageID)
+	if err != nil {
+		return fmt.Errorf("failed to mark message delivered: %w", err)
+	}
+	return nil
+}
+
+// GetUnreadCounts returns the number of unread direct messages per sender
+func (db *DB) GetUnreadCounts(userID int) (map[int]int, error) {
+	rows, err := db.conn.Query(`
+		SELECT from_user_id, COUNT(*)
+		FROM messages
+		WHERE to_user_id = $1 AND is_read = false AND deleted_at IS NULL
+		GROUP BY from_user_id
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get unread counts: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[int]int)
+	for rows.Next() {
+		var fromUserID, count int
+		if err := rows.Scan(&fromUserID, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan unread count: %w", err)
+		}
+		counts[fromUserID] = count
+	}
+	return counts, rows.Err()
+}
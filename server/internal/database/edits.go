@@ -0,0 +1,143 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Message errors
+var (
+	ErrMessageNotFound   = errors.New("message not found")
+	ErrNotMessageAuthor  = errors.New("only the author can edit this message")
+	ErrEditWindowExpired = errors.New("message can no longer be edited")
+	ErrMessageUnchanged  = errors.New("message text is unchanged")
+)
+
+// MessageEditWindow is how long after sending a message its author may edit it
+// (0 = no limit). Configured from MESSAGE_EDIT_WINDOW in main.
+var MessageEditWindow = 15 * time.Minute
+
+// MessageEdit is a previous version of an edited message
+type MessageEdit struct {
+	ID           int       `json:"id"`
+	MessageID    int       `json:"message_id"`
+	PreviousText string    `json:"previous_text"`
+	EditedBy     *int      `json:"edited_by,omitempty"`
+	EditedAt     time.Time `json:"edited_at"`
+}
+
+// GetMessageByID retrieves a single message
+func (db *DB) GetMessageByID(messageID int) (*Message, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM messages m
+		LEFT JOIN users u ON m.from_user_id = u.id
+		WHERE m.id = $1
+	`, messageSelectColumns)
+
+	rows, err := db.conn.Query(query, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message: %w", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, ErrMessageNotFound
+	}
+	return scanMessage(rows)
+}
+
+// EditMessage replaces the text of a message after checking the editor is the
+// author, may still post in the message's room and the edit window is still
+// open. The previous text is kept in message_edits.
+func (db *DB) EditMessage(messageID, editorID int, text string) (*Message, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var authorID int
+	var roomID sql.NullInt64
+	var previousText string
+	var withinWindow, deleted bool
+	err = tx.QueryRow(`
+		SELECT from_user_id, room_id, message_text,
+		       $2::float8 = 0 OR created_at > CURRENT_TIMESTAMP - make_interval(secs => $2::float8),
+		       deleted_at IS NOT NULL
+		FROM messages
+		WHERE id = $1
+		FOR UPDATE
+	`, messageID, MessageEditWindow.Seconds()).Scan(&authorID, &roomID, &previousText, &withinWindow, &deleted)
+	if err == sql.ErrNoRows {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message: %w", err)
+	}
+
+	if authorID != editorID {
+		return nil, ErrNotMessageAuthor
+	}
+	if deleted {
+		return nil, ErrMessageDeleted
+	}
+	// Muted, banned or removed members can't rewrite what they posted
+	if roomID.Valid {
+		if err := db.CheckRoomPostPermission(int(roomID.Int64), editorID); err != nil {
+			return nil, err
+		}
+	}
+	if !withinWindow {
+		return nil, ErrEditWindowExpired
+	}
+	if previousText == text {
+		return nil, ErrMessageUnchanged
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO message_edits (message_id, previous_text, edited_by)
+		VALUES ($1, $2, $3)
+	`, messageID, previousText, editorID); err != nil {
+		return nil, fmt.Errorf("failed to save edit history: %w", err)
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE messages SET message_text = $2, edited_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, messageID, text); err != nil {
+		return nil, fmt.Errorf("failed to edit message: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit edit: %w", err)
+	}
+
+	return db.GetMessageByID(messageID)
+}
+
+// GetMessageEdits retrieves the previous versions of a message, oldest first
+func (db *DB) GetMessageEdits(messageID int) ([]*MessageEdit, error) {
+	rows, err := db.conn.Query(`
+		SELECT id, message_id, previous_text, edited_by, edited_at
+		FROM message_edits
+		WHERE message_id = $1
+		ORDER BY edited_at ASC, id ASC
+	`, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message edits: %w", err)
+	}
+	defer rows.Close()
+
+	var edits []*MessageEdit
+	for rows.Next() {
+		var edit MessageEdit
+		if err := rows.Scan(&edit.ID, &edit.MessageID, &edit.PreviousText, &edit.EditedBy, &edit.EditedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan message edit: %w", err)
+		}
+		edits = append(edits, &edit)
+	}
+	return edits, rows.Err()
+}
@@ -0,0 +1,167 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// MaxHistoryLimit caps the page size of history queries
+const MaxHistoryLimit = 100
+
+// HistoryCursor selects a page of messages relative to a known message ID.
+// With neither BeforeID nor AfterID set the newest messages are returned.
+type HistoryCursor struct {
+	BeforeID int // return messages older than this ID (scrolling back)
+	AfterID  int // return messages newer than this ID (catching up)
+	Limit    int
+}
+
+// MessagePage is a page of messages in chronological order
+type MessagePage struct {
+	Messages []*Message `json:"messages"`
+	HasMore  bool       `json:"has_more"` // more messages exist in the cursor's direction
+}
+
+// messageSelectColumns are the columns scanned by scanMessage
+const messageSelectColumns = `
+	m.id, m.message_type, m.from_user_id, COALESCE(u.username, ''),
+	m.to_user_id, m.room_id, m.message_text, m.message_value,
+	m.is_read, m.read_at, m.delivered_at, m.edited_at, m.deleted_at,
+	COALESCE(m.client_msg_id, ''), m.reply_to_id, m.thread_root_id,
+	m.created_at`
+
+// notHiddenFor excludes messages the viewer (argument $N) deleted for themselves
+const notHiddenFor = ` AND NOT EXISTS (
+	SELECT 1 FROM message_hidden h WHERE h.message_id = m.id AND h.user_id = $%d)`
+
+// scanMessage scans a row selected with messageSelectColumns, followed by
+// any extra columns of the query
+func scanMessage(rows *sql.Rows, extra ...interface{}) (*Message, error) {
+	var msg Message
+	dest := []interface{}{
+		&msg.ID,
+		&msg.Type,
+		&msg.FromUserID,
+		&msg.FromUsername,
+		&msg.ToUserID,
+		&msg.RoomID,
+		&msg.Text,
+		&msg.Value,
+		&msg.IsRead,
+		&msg.ReadAt,
+		&msg.DeliveredAt,
+		&msg.EditedAt,
+		&msg.DeletedAt,
+		&msg.ClientMsgID,
+		&msg.ReplyToID,
+		&msg.ThreadRootID,
+		&msg.CreatedAt,
+	}
+	if err := rows.Scan(append(dest, extra...)...); err != nil {
+		return nil, fmt.Errorf("failed to scan message: %w", err)
+	}
+	if msg.DeletedAt != nil {
+		msg.Text = MessageTombstone
+		msg.Value = nil
+	}
+	return &msg, nil
+}
+
+// GetDirectMessagePage retrieves a page of direct messages between two users,
+// as seen by userID1 (messages they deleted for themselves are skipped).
+// Thread replies are only returned by GetThreadPage.
+func (db *DB) GetDirectMessagePage(userID1, userID2 int, cursor HistoryCursor) (*MessagePage, error) {
+	where := `((m.from_user_id = $1 AND m.to_user_id = $2)
+	        OR (m.from_user_id = $2 AND m.to_user_id = $1))
+	      AND m.thread_root_id IS NULL` + fmt.Sprintf(notHiddenFor, 1)
+	page, err := db.queryMessagePage(where, []interface{}{userID1, userID2}, cursor)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.AttachReplyCounts(page.Messages); err != nil {
+		return nil, err
+	}
+	return page, db.attachReactions(page.Messages, userID1)
+}
+
+// GetRoomMessagePage retrieves a page of messages posted in a room, as seen
+// by viewerID (0 = no viewer, hidden messages are included and no reaction is
+// marked as the viewer's). Thread replies are only returned by GetThreadPage.
+func (db *DB) GetRoomMessagePage(roomID, viewerID int, cursor HistoryCursor) (*MessagePage, error) {
+	where := `m.room_id = $1 AND m.thread_root_id IS NULL`
+	args := []interface{}{roomID}
+	if viewerID > 0 {
+		args = append(args, viewerID)
+		where += fmt.Sprintf(notHiddenFor, len(args))
+	}
+	page, err := db.queryMessagePage(where, args, cursor)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.AttachReplyCounts(page.Messages); err != nil {
+		return nil, err
+	}
+	return page, db.attachReactions(page.Messages, viewerID)
+}
+
+// queryMessagePage runs a keyset-paginated history query. One extra row is
+// fetched to know whether another page exists.
+func (db *DB) queryMessagePage(where string, args []interface{}, cursor HistoryCursor) (*MessagePage, error) {
+	if cursor.Limit <= 0 || cursor.Limit > MaxHistoryLimit {
+		cursor.Limit = MaxHistoryLimit
+	}
+
+	order := "DESC"
+	switch {
+	case cursor.AfterID > 0:
+		args = append(args, cursor.AfterID)
+		where += fmt.Sprintf(" AND m.id > $%d", len(args))
+		order = "ASC"
+	case cursor.BeforeID > 0:
+		args = append(args, cursor.BeforeID)
+		where += fmt.Sprintf(" AND m.id < $%d", len(args))
+	}
+	args = append(args, cursor.Limit+1)
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM messages m
+		LEFT JOIN users u ON m.from_user_id = u.id
+		WHERE %s
+		ORDER BY m.id %s
+		LIMIT $%d
+	`, messageSelectColumns, where, order, len(args))
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message history: %w", err)
+	}
+	defer rows.Close()
+
+	page := &MessagePage{Messages: []*Message{}}
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		page.Messages = append(page.Messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read message history: %w", err)
+	}
+
+	if len(page.Messages) > cursor.Limit {
+		page.HasMore = true
+		page.Messages = page.Messages[:cursor.Limit]
+	}
+
+	// Newest-first pages are reversed to get chronological order
+	if order == "DESC" {
+		msgs := page.Messages
+		for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+			msgs[i], msgs[j] = msgs[j], msgs[i]
+		}
+	}
+
+	return page, nil
+}
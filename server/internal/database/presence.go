@@ -0,0 +1,89 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Presence modes a user can choose
+const (
+	PresenceVisible      = "visible"   // online status shown to friends and shared-room members
+	PresenceInvisible    = "invisible" // always shown as offline
+	PresenceDoNotDisturb = "dnd"       // shown as online, with a do-not-disturb flag
+)
+
+// ErrInvalidPresenceMode is returned for an unknown presence mode
+var ErrInvalidPresenceMode = errors.New("invalid presence mode")
+
+// ValidPresenceMode reports whether mode is one of the presence modes
+func ValidPresenceMode(mode string) bool {
+	switch mode {
+	case PresenceVisible, PresenceInvisible, PresenceDoNotDisturb:
+		return true
+	}
+	return false
+}
+
+// GetPresenceMode returns the presence mode of a user
+func (db *DB) GetPresenceMode(userID int) (string, error) {
+	var mode string
+	err := db.conn.QueryRow(`SELECT presence_mode FROM users WHERE id = $1`, userID).Scan(&mode)
+	if err == sql.ErrNoRows {
+		return "", ErrUserNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get presence mode: %w", err)
+	}
+	return mode, nil
+}
+
+// SetPresenceMode changes the presence mode of a user
+func (db *DB) SetPresenceMode(userID int, mode string) error {
+	if !ValidPresenceMode(mode) {
+		return ErrInvalidPresenceMode
+	}
+
+	result, err := db.conn.Exec(`UPDATE users SET presence_mode = $2 WHERE id = $1`, userID, mode)
+	if err != nil {
+		return fmt.Errorf("failed to set presence mode: %w", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// GetPresenceAudience returns the users allowed to see a user's presence:
+// accepted friends and members of a room the user belongs to, unless either
+// of them blocked the other
+func (db *DB) GetPresenceAudience(userID int) ([]int, error) {
+	rows, err := db.conn.Query(`
+		SELECT CASE WHEN user_id = $1 THEN friend_id ELSE user_id END
+		FROM friendships
+		WHERE (user_id = $1 OR friend_id = $1) AND status = 'accepted'
+		UNION
+		SELECT other.user_id
+		FROM room_members mine
+		INNER JOIN room_members other ON other.room_id = mine.room_id
+		WHERE mine.user_id = $1
+		  AND other.user_id != $1
+		  AND NOT is_blocked($1, other.user_id)
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get presence audience: %w", err)
+	}
+	defer rows.Close()
+
+	var userIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan presence audience: %w", err)
+		}
+		userIDs = append(userIDs, id)
+	}
+	return userIDs, rows.Err()
+}
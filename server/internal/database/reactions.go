@@ -0,0 +1,96 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// ReactionSummary aggregates one emoji on a message
+type ReactionSummary struct {
+	Emoji   string `json:"emoji"`
+	Count   int    `json:"count"`
+	Reacted bool   `json:"reacted"` // the viewer reacted with this emoji
+}
+
+// AddReaction records a user's emoji on a message.
+// It returns false if the user had already reacted with that emoji.
+func (db *DB) AddReaction(messageID, userID int, emoji string) (bool, error) {
+	result, err := db.conn.Exec(`
+		INSERT INTO message_reactions (message_id, user_id, emoji)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (message_id, user_id, emoji) DO NOTHING
+	`, messageID, userID, emoji)
+	if err != nil {
+		return false, fmt.Errorf("failed to add reaction: %w", err)
+	}
+	rows, _ := result.RowsAffected()
+	return rows > 0, nil
+}
+
+// RemoveReaction removes a user's emoji from a message.
+// It returns false if the user had not reacted with that emoji.
+func (db *DB) RemoveReaction(messageID, userID int, emoji string) (bool, error) {
+	result, err := db.conn.Exec(`
+		DELETE FROM message_reactions
+		WHERE message_id = $1 AND user_id = $2 AND emoji = $3
+	`, messageID, userID, emoji)
+	if err != nil {
+		return false, fmt.Errorf("failed to remove reaction: %w", err)
+	}
+	rows, _ := result.RowsAffected()
+	return rows > 0, nil
+}
+
+// CountReactions returns how many users reacted to a message with an emoji
+func (db *DB) CountReactions(messageID int, emoji string) (int, error) {
+	var count int
+	err := db.conn.QueryRow(`
+		SELECT COUNT(*) FROM message_reactions WHERE message_id = $1 AND emoji = $2
+	`, messageID, emoji).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count reactions: %w", err)
+	}
+	return count, nil
+}
+
+// attachReactions loads the reaction summaries of messages as seen by viewerID.
+// Messages deleted for everyone keep no reactions.
+func (db *DB) attachReactions(messages []*Message, viewerID int) error {
+	byID := make(map[int]*Message, len(messages))
+	ids := make([]int64, 0, len(messages))
+	for _, msg := range messages {
+		if msg.DeletedAt != nil {
+			continue
+		}
+		byID[msg.ID] = msg
+		ids = append(ids, int64(msg.ID))
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	rows, err := db.conn.Query(`
+		SELECT message_id, emoji, COUNT(*), BOOL_OR(user_id = $2)
+		FROM message_reactions
+		WHERE message_id = ANY($1)
+		GROUP BY message_id, emoji
+		ORDER BY message_id, MIN(created_at)
+	`, pq.Array(ids), viewerID)
+	if err != nil {
+		return fmt.Errorf("failed to get reactions: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID int
+		var reaction ReactionSummary
+		if err := rows.Scan(&messageID, &reaction.Emoji, &reaction.Count, &reaction.Reacted); err != nil {
+			return fmt.Errorf("failed to scan reaction: %w", err)
+		}
+		if msg, ok := byID[messageID]; ok {
+			msg.Reactions = append(msg.Reactions, &reaction)
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,44 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// ReadReceipt describes direct messages a reader has just marked as read
+type ReadReceipt struct {
+	ReaderID int       `json:"reader_id"`
+	SenderID int       `json:"sender_id"`
+	UpToID   int       `json:"up_to_id"` // newest message ID marked as read
+	Count    int       `json:"count"`    // number of messages newly marked as read
+	ReadAt   time.Time `json:"read_at"`
+}
+
+// MarkConversationRead marks every unread message sent by senderID to readerID
+// with an ID up to upToID as read. UpToID in the receipt is the newest message
+// actually updated, Count is 0 when there was nothing left to mark.
+func (db *DB) MarkConversationRead(readerID, senderID, upToID int) (*ReadReceipt, error) {
+	receipt := &ReadReceipt{
+		ReaderID: readerID,
+		SenderID: senderID,
+	}
+
+	err := db.conn.QueryRow(`
+		WITH updated AS (
+			UPDATE messages
+			SET is_read = true, read_at = CURRENT_TIMESTAMP
+			WHERE from_user_id = $1
+			  AND to_user_id = $2
+			  AND id <= $3
+			  AND is_read = false
+			RETURNING id, read_at
+		)
+		SELECT COUNT(*), COALESCE(MAX(id), 0), COALESCE(MAX(read_at), CURRENT_TIMESTAMP)
+		FROM updated
+	`, senderID, readerID, upToID).Scan(&receipt.Count, &receipt.UpToID, &receipt.ReadAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to mark messages as read: %w", err)
+	}
+
+	return receipt, nil
+}
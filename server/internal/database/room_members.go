@@ -0,0 +1,253 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Room membership errors
+var (
+	ErrNotRoomMember = errors.New("you are not a member of this room")
+	ErrRoomBanned    = errors.New("you are banned from this room")
+	ErrRoomMuted     = errors.New("you are muted in this room")
+	ErrNotBanned     = errors.New("user is not banned from this room")
+)
+
+// Room roles ordered by privilege
+var roomRoleRank = map[string]int{
+	"member":    1,
+	"moderator": 2,
+	"admin":     3,
+}
+
+// RoomRoleRank returns the privilege rank of a room role (0 for non-members)
+func RoomRoleRank(role string) int {
+	return roomRoleRank[role]
+}
+
+// RoomMember represents a member of a room
+type RoomMember struct {
+	UserID     int        `json:"user_id"`
+	Username   string     `json:"username"`
+	AvatarURL  string     `json:"avatar_url"`
+	Role       string     `json:"role"`
+	MutedUntil *time.Time `json:"muted_until,omitempty"`
+	JoinedAt   time.Time  `json:"joined_at"`
+}
+
+// GetRoomMembers retrieves all members of a room, highest role first
+func (db *DB) GetRoomMembers(roomID int) ([]*RoomMember, error) {
+	query := `
+		SELECT u.id, u.username, COALESCE(u.avatar_url, ''), COALESCE(rm.role, 'member'),
+		       CASE WHEN rm.muted_until > CURRENT_TIMESTAMP THEN rm.muted_until END,
+		       rm.joined_at
+		FROM room_members rm
+		JOIN users u ON u.id = rm.user_id
+		WHERE rm.room_id = $1
+		ORDER BY CASE rm.role WHEN 'admin' THEN 0 WHEN 'moderator' THEN 1 ELSE 2 END, u.username ASC
+	`
+
+	rows, err := db.conn.Query(query, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get room members: %w", err)
+	}
+	defer rows.Close()
+
+	var members []*RoomMember
+	for rows.Next() {
+		var member RoomMember
+		err := rows.Scan(
+			&member.UserID,
+			&member.Username,
+			&member.AvatarURL,
+			&member.Role,
+			&member.MutedUntil,
+			&member.JoinedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan room member: %w", err)
+		}
+		members = append(members, &member)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get room members: %w", err)
+	}
+
+	return members, nil
+}
+
+// RemoveRoomMember removes a user from a room
+func (db *DB) RemoveRoomMember(roomID, userID int) error {
+	result, err := db.conn.Exec(`DELETE FROM room_members WHERE room_id = $1 AND user_id = $2`, roomID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to remove room member: %w", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return ErrNotRoomMember
+	}
+	return nil
+}
+
+// SetRoomMemberRole changes a member's role
+func (db *DB) SetRoomMemberRole(roomID, userID int, role string) error {
+	result, err := db.conn.Exec(
+		`UPDATE room_members SET role = $3 WHERE room_id = $1 AND user_id = $2`,
+		roomID, userID, role,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set room role: %w", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return ErrNotRoomMember
+	}
+	return nil
+}
+
+// MuteRoomMember mutes a member until the given time (nil unmutes)
+func (db *DB) MuteRoomMember(roomID, userID int, until *time.Time) error {
+	result, err := db.conn.Exec(
+		`UPDATE room_members SET muted_until = $3 WHERE room_id = $1 AND user_id = $2`,
+		roomID, userID, until,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to mute room member: %w", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return ErrNotRoomMember
+	}
+	return nil
+}
+
+// BanRoomMember removes a user from a room and bans them until the given time (nil = permanent)
+func (db *DB) BanRoomMember(roomID, userID, bannedBy int, reason string, until *time.Time) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM room_members WHERE room_id = $1 AND user_id = $2`, roomID, userID); err != nil {
+		return fmt.Errorf("failed to remove banned member: %w", err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO room_bans (room_id, user_id, banned_by, reason, expires_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5)
+		ON CONFLICT (room_id, user_id)
+		DO UPDATE SET banned_by = EXCLUDED.banned_by, reason = EXCLUDED.reason,
+		              expires_at = EXCLUDED.expires_at, created_at = CURRENT_TIMESTAMP
+	`, roomID, userID, bannedBy, reason, until)
+	if err != nil {
+		return fmt.Errorf("failed to ban room member: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit ban: %w", err)
+	}
+	return nil
+}
+
+// UnbanRoomMember lifts a ban
+func (db *DB) UnbanRoomMember(roomID, userID int) error {
+	result, err := db.conn.Exec(`DELETE FROM room_bans WHERE room_id = $1 AND user_id = $2`, roomID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to unban room member: %w", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return ErrNotBanned
+	}
+	return nil
+}
+
+// IsRoomBanned checks whether a user has an active ban in a room
+func (db *DB) IsRoomBanned(roomID, userID int) (bool, error) {
+	var banned bool
+	err := db.conn.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM room_bans
+			WHERE room_id = $1 AND user_id = $2
+			  AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)
+		)
+	`, roomID, userID).Scan(&banned)
+	if err != nil {
+		return false, fmt.Errorf("failed to check room ban: %w", err)
+	}
+	return banned, nil
+}
+
+// CanReadRoom reports whether a user may read a room's messages:
+// public rooms are readable by everyone (unless banned), others by members only
+func (db *DB) CanReadRoom(roomID, userID int) (bool, error) {
+	room, err := db.GetRoomByID(roomID)
+	if err != nil {
+		return false, err
+	}
+
+	banned, err := db.IsRoomBanned(roomID, userID)
+	if err != nil {
+		return false, err
+	}
+	if banned {
+		return false, nil
+	}
+
+	if room.RoomType == "public" {
+		return true, nil
+	}
+	return db.IsRoomMember(roomID, userID)
+}
+
+// CanSeeMessage reports whether a message belongs to a conversation the user
+// may read: a direct conversation of theirs or a room they can read
+func (db *DB) CanSeeMessage(msg *Message, userID int) (bool, error) {
+	if msg.FromUserID == userID || (msg.ToUserID != nil && *msg.ToUserID == userID) {
+		return true, nil
+	}
+	if msg.RoomID == nil {
+		return false, nil
+	}
+	allowed, err := db.CanReadRoom(*msg.RoomID, userID)
+	if errors.Is(err, ErrRoomNotFound) {
+		return false, nil
+	}
+	return allowed, err
+}
+
+// CheckRoomPostPermission returns nil if the user may post to the room,
+// otherwise ErrRoomBanned, ErrNotRoomMember or ErrRoomMuted
+func (db *DB) CheckRoomPostPermission(roomID, userID int) error {
+	banned, err := db.IsRoomBanned(roomID, userID)
+	if err != nil {
+		return err
+	}
+	if banned {
+		return ErrRoomBanned
+	}
+
+	var muted bool
+	err = db.conn.QueryRow(`
+		SELECT COALESCE(muted_until > CURRENT_TIMESTAMP, false)
+		FROM room_members
+		WHERE room_id = $1 AND user_id = $2
+	`, roomID, userID).Scan(&muted)
+	if err == sql.ErrNoRows {
+		return ErrNotRoomMember
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check room permissions: %w", err)
+	}
+	if muted {
+		return ErrRoomMuted
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package database
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"time"
+)
+
+// MaxSearchLimit caps the page size of message searches
+const MaxSearchLimit = 50
+
+// ts_headline wraps matches in these control characters; they are turned
+// into <mark> tags once the snippet has been HTML-escaped
+const (
+	snippetStartSel = "\x02"
+	snippetStopSel  = "\x03"
+)
+
+var headlineOptions = `StartSel="` + snippetStartSel + `", StopSel="` + snippetStopSel + `", ` +
+	`MaxWords=24, MinWords=8, ShortWord=1, MaxFragments=2, FragmentDelimiter=" … "`
+
+// MessageSearch is a message search request. Results are newest first,
+// BeforeID continues from the last result of the previous page.
+type MessageSearch struct {
+	Query      string
+	FromUserID int        // only messages sent by this user
+	WithUserID int        // only the direct conversation with this user
+	RoomID     int        // only this room
+	Since      *time.Time // created at or after
+	Until      *time.Time // created before
+	BeforeID   int
+	Limit      int
+}
+
+// SearchResult is a matching message with a highlighted excerpt
+type SearchResult struct {
+	Message *Message `json:"message"`
+	Snippet string   `json:"snippet"` // HTML-escaped, matches wrapped in <mark>
+}
+
+// SearchPage is a page of search results
+type SearchPage struct {
+	Results []*SearchResult `json:"results"`
+	HasMore bool            `json:"has_more"`
+}
+
+// SearchMessages searches the messages a user can see (their direct messages
+// and the rooms they belong to), ignoring case and Vietnamese diacritics.
+// Whole words match through the full-text index, partial words through the
+// trigram index.
+func (db *DB) SearchMessages(userID int, search MessageSearch) (*SearchPage, error) {
+	if search.Limit <= 0 || search.Limit > MaxSearchLimit {
+		search.Limit = MaxSearchLimit
+	}
+
+	args := []interface{}{userID, search.Query, headlineOptions, EscapeLike(search.Query)}
+	where := `m.deleted_at IS NULL
+		  AND ((m.to_user_id IS NOT NULL AND (m.from_user_id = $1 OR m.to_user_id = $1))
+		       OR m.room_id IN (SELECT rm.room_id FROM room_members rm WHERE rm.user_id = $1))
+		  AND (m.search_vector @@ plainto_tsquery('vn_unaccent', $2)
+		       OR immutable_unaccent(lower(m.message_text)) LIKE '%' || immutable_unaccent(lower($4)) || '%')` +
+		fmt.Sprintf(notHiddenFor, 1)
+
+	if search.FromUserID > 0 {
+		args = append(args, search.FromUserID)
+		where += fmt.Sprintf(" AND m.from_user_id = $%d", len(args))
+	}
+	if search.WithUserID > 0 {
+		args = append(args, search.WithUserID)
+		where += fmt.Sprintf(` AND ((m.from_user_id = $1 AND m.to_user_id = $%[1]d)
+		                        OR (m.from_user_id = $%[1]d AND m.to_user_id = $1))`, len(args))
+	}
+	if search.RoomID > 0 {
+		args = append(args, search.RoomID)
+		where += fmt.Sprintf(" AND m.room_id = $%d", len(args))
+	}
+	if search.Since != nil {
+		args = append(args, *search.Since)
+		where += fmt.Sprintf(" AND m.created_at >= $%d", len(args))
+	}
+	if search.Until != nil {
+		args = append(args, *search.Until)
+		where += fmt.Sprintf(" AND m.created_at < $%d", len(args))
+	}
+	if search.BeforeID > 0 {
+		args = append(args, search.BeforeID)
+		where += fmt.Sprintf(" AND m.id < $%d", len(args))
+	}
+	args = append(args, search.Limit+1)
+
+	query := fmt.Sprintf(`
+		SELECT %s,
+		       ts_headline('vn_unaccent', m.message_text, plainto_tsquery('vn_unaccent', $2), $3)
+		FROM messages m
+		LEFT JOIN users u ON m.from_user_id = u.id
+		WHERE %s
+		ORDER BY m.id DESC
+		LIMIT $%d
+	`, messageSelectColumns, where, len(args))
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search messages: %w", err)
+	}
+	defer rows.Close()
+
+	page := &SearchPage{Results: []*SearchResult{}}
+	for rows.Next() {
+		var snippet string
+		msg, err := scanMessage(rows, &snippet)
+		if err != nil {
+			return nil, err
+		}
+		page.Results = append(page.Results, &SearchResult{
+			Message: msg,
+			Snippet: highlightSnippet(snippet),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read search results: %w", err)
+	}
+
+	if len(page.Results) > search.Limit {
+		page.HasMore = true
+		page.Results = page.Results[:search.Limit]
+	}
+	return page, nil
+}
+
+// EscapeLike escapes the LIKE wildcards of user input
+func EscapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// highlightSnippet HTML-escapes a ts_headline excerpt and marks its matches
+func highlightSnippet(snippet string) string {
+	return strings.NewReplacer(snippetStartSel, "<mark>", snippetStopSel, "</mark>").
+		Replace(html.EscapeString(snippet))
+}
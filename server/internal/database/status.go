@@ -0,0 +1,72 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Custom status limits (match the users.status_* columns)
+const (
+	MaxStatusTextLength  = 140
+	MaxStatusEmojiLength = 32
+)
+
+// ActiveStatusColumns selects the custom status of users u (text, emoji,
+// expiry), empty once expired even if not cleared yet
+const ActiveStatusColumns = `
+	CASE WHEN u.status_expires_at IS NULL OR u.status_expires_at > NOW() THEN COALESCE(u.status_text, '') ELSE '' END,
+	CASE WHEN u.status_expires_at IS NULL OR u.status_expires_at > NOW() THEN COALESCE(u.status_emoji, '') ELSE '' END,
+	CASE WHEN u.status_expires_at > NOW() THEN u.status_expires_at END`
+
+// GetUserProfile retrieves the public profile of a user with their custom
+// status. An expired status is returned empty even if not cleared yet.
+func (db *DB) GetUserProfile(userID int) (*User, error) {
+	var user User
+	err := db.conn.QueryRow(`
+		SELECT u.id, u.username, u.email, COALESCE(u.avatar_url, ''),
+		       `+ActiveStatusColumns+`
+		FROM users u
+		WHERE u.id = $1
+	`, userID).Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.AvatarURL,
+		&user.StatusText,
+		&user.StatusEmoji,
+		&user.StatusExpiresAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user profile: %w", err)
+	}
+	return &user, nil
+}
+
+// ClearExpiredStatuses removes the custom statuses that expired and returns
+// the users they belonged to. Each row is only returned to one caller, so
+// several instances can run it concurrently.
+func (db *DB) ClearExpiredStatuses() ([]int, error) {
+	rows, err := db.conn.Query(`
+		UPDATE users
+		SET status_text = NULL, status_emoji = NULL, status_expires_at = NULL
+		WHERE status_expires_at <= NOW()
+		RETURNING id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to clear expired statuses: %w", err)
+	}
+	defer rows.Close()
+
+	var userIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan cleared status: %w", err)
+		}
+		userIDs = append(userIDs, id)
+	}
+	return userIDs, rows.Err()
+}
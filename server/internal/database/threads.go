@@ -0,0 +1,127 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// ErrInvalidReply is returned when a reply references a message of another conversation
+var ErrInvalidReply = errors.New("reply must reference a message in the same conversation")
+
+// SetReply sets the reply references of a new message (0 = none)
+func (m *Message) SetReply(replyToID, threadRootID int) {
+	m.ReplyToID, m.ThreadRootID = nil, nil
+	if replyToID > 0 {
+		m.ReplyToID = &replyToID
+	}
+	if threadRootID > 0 {
+		m.ThreadRootID = &threadRootID
+	}
+}
+
+// resolveThread validates the reply references of a new message and fills in
+// its thread root. Replying to a message inside a thread posts in that thread,
+// and a thread reply given as root is followed up to the actual root.
+func (db *DB) resolveThread(msg *Message) error {
+	if msg.ThreadRootID != nil {
+		root, err := db.getReplyTarget(msg, *msg.ThreadRootID)
+		if err != nil {
+			return err
+		}
+		if root.ThreadRootID != nil {
+			rootID := *root.ThreadRootID
+			msg.ThreadRootID = &rootID
+		}
+	}
+
+	if msg.ReplyToID != nil {
+		parent, err := db.getReplyTarget(msg, *msg.ReplyToID)
+		if err != nil {
+			return err
+		}
+		switch {
+		case msg.ThreadRootID == nil:
+			msg.ThreadRootID = parent.ThreadRootID
+		case parent.ID != *msg.ThreadRootID &&
+			(parent.ThreadRootID == nil || *parent.ThreadRootID != *msg.ThreadRootID):
+			return ErrInvalidReply // quoting a message of another thread
+		}
+	}
+	return nil
+}
+
+// getReplyTarget loads a message referenced by a new message and checks both
+// belong to the same room or direct conversation
+func (db *DB) getReplyTarget(msg *Message, targetID int) (*Message, error) {
+	target, err := db.GetMessageByID(targetID)
+	if errors.Is(err, ErrMessageNotFound) {
+		return nil, ErrInvalidReply
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var same bool
+	switch {
+	case msg.RoomID != nil:
+		same = target.RoomID != nil && *target.RoomID == *msg.RoomID
+	case msg.ToUserID != nil && target.ToUserID != nil:
+		same = (target.FromUserID == msg.FromUserID && *target.ToUserID == *msg.ToUserID) ||
+			(target.FromUserID == *msg.ToUserID && *target.ToUserID == msg.FromUserID)
+	}
+	if !same {
+		return nil, ErrInvalidReply
+	}
+	return target, nil
+}
+
+// GetThreadPage retrieves a page of the replies in a thread, as seen by viewerID
+func (db *DB) GetThreadPage(rootID, viewerID int, cursor HistoryCursor) (*MessagePage, error) {
+	where := `m.thread_root_id = $1` + fmt.Sprintf(notHiddenFor, 2)
+	page, err := db.queryMessagePage(where, []interface{}{rootID, viewerID}, cursor)
+	if err != nil {
+		return nil, err
+	}
+	return page, db.attachReactions(page.Messages, viewerID)
+}
+
+// AttachReplyCounts loads how many replies the threads rooted at messages have.
+// Only history pages and thread roots show it, so it isn't a message column.
+func (db *DB) AttachReplyCounts(messages []*Message) error {
+	byID := make(map[int]*Message, len(messages))
+	ids := make([]int64, 0, len(messages))
+	for _, msg := range messages {
+		if msg.ThreadRootID != nil {
+			continue // replies can't have replies of their own
+		}
+		byID[msg.ID] = msg
+		ids = append(ids, int64(msg.ID))
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	rows, err := db.conn.Query(`
+		SELECT thread_root_id, COUNT(*)
+		FROM messages
+		WHERE thread_root_id = ANY($1) AND deleted_at IS NULL
+		GROUP BY thread_root_id
+	`, pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("failed to get reply counts: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rootID, count int
+		if err := rows.Scan(&rootID, &count); err != nil {
+			return fmt.Errorf("failed to scan reply count: %w", err)
+		}
+		if msg, ok := byID[rootID]; ok {
+			msg.ReplyCount = count
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"e5realtimechat/internal/auth"
+)
+
+// ErrUserBlocked is returned when one of two users blocked the other
+var ErrUserBlocked = errors.New("user is blocked")
+
+// BlockUser blocks a user: any friendship or pending request between them is
+// removed and replaced by a 'blocked' row owned by the blocker
+func (s *FriendsService) BlockUser(blockerID, blockedID int) error {
+	var exists bool
+	if err := s.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)`, blockedID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return auth.ErrUserNotFound
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The other user's own block of the blocker is kept
+	if _, err := tx.Exec(`
+		DELETE FROM friendships
+		WHERE ((user_id = $1 AND friend_id = $2) OR (user_id = $2 AND friend_id = $1))
+		  AND status != 'blocked'
+	`, blockerID, blockedID); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO friendships (user_id, friend_id, status, created_at, updated_at)
+		VALUES ($1, $2, 'blocked', NOW(), NOW())
+		ON CONFLICT (user_id, friend_id) DO NOTHING
+	`, blockerID, blockedID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// Invalidate cache for both users
+	if s.cacheService != nil {
+		s.cacheService.InvalidateFriendsList(blockerID)
+		s.cacheService.InvalidateFriendsList(blockedID)
+	}
+
+	return nil
+}
+
+// UnblockUser removes a block set by blockerID
+func (s *FriendsService) UnblockUser(blockerID, blockedID int) error {
+	result, err := s.db.Exec(`
+		DELETE FROM friendships
+		WHERE user_id = $1 AND friend_id = $2 AND status = 'blocked'
+	`, blockerID, blockedID)
+	if err != nil {
+		return err
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return auth.ErrUserNotFound // No block found
+	}
+
+	// Invalidate cache for both users
+	if s.cacheService != nil {
+		s.cacheService.InvalidateFriendsList(blockerID)
+		s.cacheService.InvalidateFriendsList(blockedID)
+	}
+
+	return nil
+}
+
+// GetBlockedUsers retrieves the users blocked by a user, most recent first
+func (s *FriendsService) GetBlockedUsers(userID int) ([]Friend, error) {
+	rows, err := s.db.Query(`
+		SELECT u.id, u.username, COALESCE(u.avatar_url, '')
+		FROM users u
+		INNER JOIN friendships f ON u.id = f.friend_id
+		WHERE f.user_id = $1 AND f.status = 'blocked'
+		ORDER BY f.created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []Friend{}
+	for rows.Next() {
+		var user Friend
+		if err := rows.Scan(&user.ID, &user.Username, &user.AvatarURL); err != nil {
+			return nil, err
+		}
+		user.Name = user.Username
+		user.Avatar = user.AvatarURL
+		user.Status = "blocked"
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// blockRequest is the payload of block / unblock
+type blockRequest struct {
+	UserID int `json:"user_id"`
+}
+
+// blockUserHandler blocks a user
+func blockUserHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req blockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID <= 0 {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.UserID == claims.UserID {
+		http.Error(w, "Cannot block yourself", http.StatusBadRequest)
+		return
+	}
+
+	err := friendsService.BlockUser(claims.UserID, req.UserID)
+	if errors.Is(err, auth.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Error blocking user: %v", err)
+		http.Error(w, "Failed to block user", http.StatusInternalServerError)
+		return
+	}
+
+	// The blocked user's open sessions stop showing the blocker as online
+	if friendsService.hub != nil {
+		friendsService.hub.SendOfflineStatus(claims.UserID, claims.Username, req.UserID)
+	}
+	log.Printf("🚫 User %d blocked user %d", claims.UserID, req.UserID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "User blocked",
+	})
+}
+
+// unblockUserHandler removes a block
+func unblockUserHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req blockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID <= 0 {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	err := friendsService.UnblockUser(claims.UserID, req.UserID)
+	if errors.Is(err, auth.ErrUserNotFound) {
+		http.Error(w, "User is not blocked", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Error unblocking user: %v", err)
+		http.Error(w, "Failed to unblock user", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("✅ User %d unblocked user %d", claims.UserID, req.UserID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "User unblocked",
+	})
+}
+
+// getBlockedUsersHandler lists the users blocked by the caller
+func getBlockedUsersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	claims, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	users, err := friendsService.GetBlockedUsers(claims.UserID)
+	if err != nil {
+		log.Printf("❌ Error getting blocked users: %v", err)
+		http.Error(w, "Failed to get blocked users", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(users)
+}
+
+// BlockUserHandler returns handler for blocking users
+func BlockUserHandler(service *FriendsService) http.HandlerFunc {
+	friendsService = service
+	return blockUserHandler
+}
+
+// UnblockUserHandler returns handler for unblocking users
+func UnblockUserHandler(service *FriendsService) http.HandlerFunc {
+	friendsService = service
+	return unblockUserHandler
+}
+
+// GetBlockedUsersHandler returns handler for listing blocked users
+func GetBlockedUsersHandler(service *FriendsService) http.HandlerFunc {
+	friendsService = service
+	return getBlockedUsersHandler
+}
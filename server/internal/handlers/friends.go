@@ -3,12 +3,16 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"e5realtimechat/internal/auth"
 	"e5realtimechat/internal/cache"
+	"e5realtimechat/internal/database"
 )
 
 // Cấu trúc dữ liệu bạn bè
@@ -22,11 +26,22 @@ type Friend struct {
 	Online    bool   `json:"online"`
 	IsOnline  bool   `json:"is_online"`
 	Status    string `json:"status,omitempty"`
+	Presence  string `json:"presence,omitempty"` // "visible" or "dnd" while online
+
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty"` // set by GetUserFriends for offline friends
+
+	// Custom status, empty once expired
+	StatusText      string     `json:"status_text,omitempty"`
+	StatusEmoji     string     `json:"status_emoji,omitempty"`
+	StatusExpiresAt *time.Time `json:"status_expires_at,omitempty"`
+
+	MutualFriends int `json:"mutual_friends,omitempty"` // set by SearchUsers
 }
 
 // FriendsService handles friend-related operations
 type FriendsService struct {
 	db           *sql.DB
+	store        *database.DB // queries shared with the WebSocket hub (blocks, presence)
 	cacheService *cache.CacheService
 	hub          HubInterface // WebSocket hub for realtime notifications
 }
@@ -34,12 +49,16 @@ type FriendsService struct {
 // HubInterface defines methods needed from websocket Hub
 type HubInterface interface {
 	SendDirectMessage(message []byte, toUserID int)
+	UpdatePresenceMode(userID int, username, mode string)
+	NotifyUserStatus(userID int, username string)
+	SendOfflineStatus(userID int, username string, toUserIDs ...int)
 }
 
 // NewFriendsService creates a new friends service
 func NewFriendsService(db *sql.DB) *FriendsService {
 	return &FriendsService{
 		db:           db,
+		store:        database.NewDBFromConnection(db),
 		cacheService: nil, // Will be set later
 		hub:          nil, // Will be set later
 	}
@@ -59,7 +78,8 @@ func (s *FriendsService) SetHub(hub HubInterface) {
 func (s *FriendsService) GetUserFriends(userID int) ([]Friend, error) {
 	// Fetch from database (don't cache online status as it changes frequently)
 	query := `
-		SELECT u.id, u.username, u.email, COALESCE(u.avatar_url, '')
+		SELECT u.id, u.username, u.email, COALESCE(u.avatar_url, ''), u.presence_mode, u.last_seen_at,
+		       ` + database.ActiveStatusColumns + `
 		FROM users u
 		INNER JOIN friendships f ON (u.id = f.friend_id OR u.id = f.user_id)
 		WHERE (f.user_id = $1 OR f.friend_id = $1) 
@@ -77,20 +97,29 @@ func (s *FriendsService) GetUserFriends(userID int) ([]Friend, error) {
 	var friends []Friend
 	for rows.Next() {
 		var friend Friend
-		err := rows.Scan(&friend.ID, &friend.Username, &friend.Email, &friend.AvatarURL)
+		var mode string
+		var lastSeen *time.Time
+		err := rows.Scan(&friend.ID, &friend.Username, &friend.Email, &friend.AvatarURL, &mode, &lastSeen,
+			&friend.StatusText, &friend.StatusEmoji, &friend.StatusExpiresAt)
 		if err != nil {
 			return nil, err
 		}
 
-		// Check online status from Redis in real-time
-		if s.cacheService != nil {
+		// Check online status from Redis in real-time (invisible users appear offline)
+		if s.cacheService != nil && mode != database.PresenceInvisible {
 			isOnline, err := s.cacheService.IsUserOnline(friend.ID)
-			if err == nil {
-				friend.IsOnline = isOnline
-				friend.Online = isOnline
+			if err == nil && isOnline {
+				friend.IsOnline = true
+				friend.Online = true
+				friend.Presence = mode
 			}
 		}
 
+		// "Last seen X ago" for offline friends, invisible users don't reveal it
+		if !friend.IsOnline && mode != database.PresenceInvisible {
+			friend.LastSeenAt = lastSeen
+		}
+
 		// Set aliases for frontend compatibility
 		friend.Name = friend.Username
 		friend.Avatar = friend.AvatarURL
@@ -110,65 +139,126 @@ func (s *FriendsService) GetUserFriends(userID int) ([]Friend, error) {
 	return friends, nil
 }
 
-// SearchUsers searches for users by username or email
-func (s *FriendsService) SearchUsers(query string, currentUserID int, limit int) ([]Friend, error) {
+// MaxUserSearchLimit caps the page size of user searches
+const MaxUserSearchLimit = 50
+
+// SearchUsers searches users by username, ranked by trigram similarity with
+// users sharing friends with the current user first. A query containing "@"
+// only matches an exact email address, so emails can't be discovered by
+// substring. Users who blocked the current user are never returned.
+func (s *FriendsService) SearchUsers(query string, currentUserID int, limit, offset int) ([]Friend, error) {
+	if limit <= 0 || limit > MaxUserSearchLimit {
+		limit = MaxUserSearchLimit
+	}
+
+	normalized := strings.ToLower(query)
+	args := []interface{}{currentUserID, normalized, limit, offset}
+	match := `LOWER(u.email) = $2`
+	if !strings.Contains(query, "@") {
+		args = append(args, database.EscapeLike(normalized))
+		match = `(LOWER(u.username) LIKE '%' || $5 || '%' OR $2 <% LOWER(u.username))`
+	}
+
+	// Mutual friends are counted in a lateral join: ORDER BY only accepts an
+	// output column name on its own, not inside an expression like "> 0"
 	searchQuery := `
-		SELECT u.id, u.username, u.email, COALESCE(u.avatar_url, ''),
+		WITH my_friends AS (
+			SELECT CASE WHEN user_id = $1 THEN friend_id ELSE user_id END AS id
+			FROM friendships
+			WHERE (user_id = $1 OR friend_id = $1) AND status = 'accepted'
+		)
+		SELECT u.id, u.username, COALESCE(u.avatar_url, ''), u.presence_mode,
+		       ` + database.ActiveStatusColumns + `,
 		       CASE 
 		           WHEN f.id IS NOT NULL AND f.status = 'accepted' THEN 'friend'
 		           WHEN f.id IS NOT NULL AND f.status = 'pending' THEN 'pending'
+		           WHEN f.id IS NOT NULL AND f.status = 'blocked' THEN 'blocked'
 		           ELSE 'none'
-		       END as friendship_status
+		       END as friendship_status,
+		       mutual.mutual_friends
 		FROM users u
 		LEFT JOIN friendships f ON (
 		    (f.user_id = $1 AND f.friend_id = u.id) OR 
 		    (f.friend_id = $1 AND f.user_id = u.id)
 		)
+		CROSS JOIN LATERAL (
+		    SELECT COUNT(*) AS mutual_friends FROM friendships mf
+		    WHERE mf.status = 'accepted'
+		      AND ((mf.user_id = u.id AND mf.friend_id IN (SELECT id FROM my_friends))
+		        OR (mf.friend_id = u.id AND mf.user_id IN (SELECT id FROM my_friends)))
+		) mutual
 		WHERE u.id != $1 
-		  AND (LOWER(u.username) LIKE LOWER($2) OR LOWER(u.email) LIKE LOWER($2))
-		ORDER BY u.username ASC
-		LIMIT $3
+		  AND NOT EXISTS (
+		      SELECT 1 FROM friendships b
+		      WHERE b.user_id = u.id AND b.friend_id = $1 AND b.status = 'blocked'
+		  )
+		  AND ` + match + `
+		ORDER BY mutual.mutual_friends > 0 DESC,
+		         LOWER(u.username) = $2 DESC,
+		         word_similarity($2, LOWER(u.username)) DESC,
+		         mutual.mutual_friends DESC,
+		         u.username ASC
+		LIMIT $3 OFFSET $4
 	`
 
-	searchPattern := "%" + query + "%"
-	rows, err := s.db.Query(searchQuery, currentUserID, searchPattern, limit)
+	rows, err := s.db.Query(searchQuery, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var users []Friend
+	users := []Friend{}
 	for rows.Next() {
 		var user Friend
-		var status string
-		err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.AvatarURL, &status)
+		var mode, status string
+		var statusText, statusEmoji string
+		var statusExpiresAt *time.Time
+		err := rows.Scan(&user.ID, &user.Username, &user.AvatarURL, &mode,
+			&statusText, &statusEmoji, &statusExpiresAt, &status, &user.MutualFriends)
 		if err != nil {
 			return nil, err
 		}
 
-		// Check online status from Redis in real-time
-		if s.cacheService != nil {
+		// Presence is only shown to friends, and invisible users appear offline
+		if s.cacheService != nil && status == "friend" && mode != database.PresenceInvisible {
 			isOnline, err := s.cacheService.IsUserOnline(user.ID)
-			if err == nil {
-				user.IsOnline = isOnline
-				user.Online = isOnline
+			if err == nil && isOnline {
+				user.IsOnline = true
+				user.Online = true
+				user.Presence = mode
 			}
 		}
 
+		// Like presence, the custom status is only shown to friends
+		if status == "friend" {
+			user.StatusText = statusText
+			user.StatusEmoji = statusEmoji
+			user.StatusExpiresAt = statusExpiresAt
+		}
+
 		user.Name = user.Username
 		user.Avatar = user.AvatarURL
 		user.Status = status
 		users = append(users, user)
 	}
 
-	return users, nil
+	return users, rows.Err()
 }
 
 // SendFriendRequest sends a friend request
 func (s *FriendsService) SendFriendRequest(fromUserID, toUserID int) error {
+	// Neither user may have blocked the other
+	blocked, err := s.store.IsBlocked(fromUserID, toUserID)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return ErrUserBlocked
+	}
+
 	// Check if friendship already exists
 	var exists bool
-	err := s.db.QueryRow(`
+	err = s.db.QueryRow(`
 		SELECT EXISTS(
 			SELECT 1 FROM friendships 
 			WHERE (user_id = $1 AND friend_id = $2) OR (user_id = $2 AND friend_id = $1)
@@ -245,6 +335,101 @@ func (s *FriendsService) RejectFriendRequest(userID, friendID int) error {
 	return nil
 }
 
+// RemoveFriend removes an accepted friendship, from either side
+func (s *FriendsService) RemoveFriend(userID, friendID int) error {
+	result, err := s.db.Exec(`
+		DELETE FROM friendships 
+		WHERE ((user_id = $1 AND friend_id = $2) OR (user_id = $2 AND friend_id = $1))
+		  AND status = 'accepted'
+	`, userID, friendID)
+	if err != nil {
+		return err
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return auth.ErrUserNotFound // Not friends
+	}
+
+	// Invalidate cache for both users
+	if s.cacheService != nil {
+		s.cacheService.InvalidateFriendsList(userID)
+		s.cacheService.InvalidateFriendsList(friendID)
+	}
+
+	return nil
+}
+
+// CancelFriendRequest withdraws a pending request sent by userID
+func (s *FriendsService) CancelFriendRequest(userID, friendID int) error {
+	result, err := s.db.Exec(`
+		DELETE FROM friendships 
+		WHERE user_id = $1 AND friend_id = $2 AND status = 'pending'
+	`, userID, friendID)
+	if err != nil {
+		return err
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return auth.ErrUserNotFound // No pending request found
+	}
+
+	// Invalidate cache for both users
+	if s.cacheService != nil {
+		s.cacheService.InvalidateFriendsList(userID)
+		s.cacheService.InvalidateFriendsList(friendID)
+	}
+
+	return nil
+}
+
+// GetSentFriendRequests gets the pending requests sent by a user
+func (s *FriendsService) GetSentFriendRequests(userID int) ([]Friend, error) {
+	query := `
+		SELECT u.id, u.username, COALESCE(u.avatar_url, '')
+		FROM users u
+		INNER JOIN friendships f ON u.id = f.friend_id
+		WHERE f.user_id = $1 AND f.status = 'pending'
+		ORDER BY f.created_at DESC
+	`
+
+	rows, err := s.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := []Friend{}
+	for rows.Next() {
+		var user Friend
+		err := rows.Scan(&user.ID, &user.Username, &user.AvatarURL)
+		if err != nil {
+			return nil, err
+		}
+		user.Name = user.Username
+		user.Avatar = user.AvatarURL
+		user.Status = "pending"
+		requests = append(requests, user)
+	}
+
+	return requests, rows.Err()
+}
+
+// notify sends a realtime friendship notification to a user
+func (s *FriendsService) notify(userID int, notification map[string]interface{}) {
+	if s.hub == nil {
+		return
+	}
+	notifBytes, err := json.Marshal(notification)
+	if err != nil {
+		log.Printf("⚠️ Failed to marshal %v notification: %v", notification["type"], err)
+		return
+	}
+	s.hub.SendDirectMessage(notifBytes, userID)
+	log.Printf("📬 Sent %v notification to user %d", notification["type"], userID)
+}
+
 // GetFriendRequests gets pending friend requests for a user
 func (s *FriendsService) GetFriendRequests(userID int) ([]Friend, error) {
 	query := `
@@ -339,7 +524,16 @@ func searchUsersHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	users, err := friendsService.SearchUsers(query, claims.UserID, 20)
+	// Pagination (default 20 per page)
+	limit, offset := 20, 0
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 {
+		limit = l
+	}
+	if o, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && o > 0 {
+		offset = o
+	}
+
+	users, err := friendsService.SearchUsers(query, claims.UserID, limit, offset)
 	if err != nil {
 		log.Printf("❌ Error searching users: %v", err)
 		http.Error(w, "Failed to search users", http.StatusInternalServerError)
@@ -386,6 +580,10 @@ func sendFriendRequestHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	err := friendsService.SendFriendRequest(claims.UserID, req.FriendID)
+	if errors.Is(err, ErrUserBlocked) {
+		http.Error(w, "Cannot send friend request to this user", http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		log.Printf("❌ Error sending friend request: %v", err)
 		http.Error(w, "Failed to send friend request", http.StatusInternalServerError)
@@ -487,6 +685,14 @@ func acceptFriendRequestHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Let the requester know
+	friendsService.notify(req.FriendID, map[string]interface{}{
+		"type":          "friend_request_accepted",
+		"from_user_id":  claims.UserID,
+		"from_username": claims.Username,
+		"message":       claims.Username + " accepted your friend request",
+	})
+
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -531,6 +737,13 @@ func rejectFriendRequestHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The requester's sent requests list changes too
+	friendsService.notify(req.FriendID, map[string]interface{}{
+		"type":          "friend_request_rejected",
+		"from_user_id":  claims.UserID,
+		"from_username": claims.Username,
+	})
+
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -539,6 +752,143 @@ func rejectFriendRequestHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// friendIDRequest is the payload of friendship actions
+type friendIDRequest struct {
+	FriendID int `json:"friend_id"`
+}
+
+// removeFriendHandler removes an accepted friendship
+func removeFriendHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req friendIDRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.FriendID <= 0 {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	err := friendsService.RemoveFriend(claims.UserID, req.FriendID)
+	if errors.Is(err, auth.ErrUserNotFound) {
+		http.Error(w, "Not friends with this user", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Error removing friend: %v", err)
+		http.Error(w, "Failed to remove friend", http.StatusInternalServerError)
+		return
+	}
+
+	friendsService.notify(req.FriendID, map[string]interface{}{
+		"type":          "friend_removed",
+		"from_user_id":  claims.UserID,
+		"from_username": claims.Username,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Friend removed",
+	})
+}
+
+// cancelFriendRequestHandler withdraws an outgoing friend request
+func cancelFriendRequestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req friendIDRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.FriendID <= 0 {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	err := friendsService.CancelFriendRequest(claims.UserID, req.FriendID)
+	if errors.Is(err, auth.ErrUserNotFound) {
+		http.Error(w, "No pending request to this user", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Error cancelling friend request: %v", err)
+		http.Error(w, "Failed to cancel friend request", http.StatusInternalServerError)
+		return
+	}
+
+	friendsService.notify(req.FriendID, map[string]interface{}{
+		"type":          "friend_request_cancelled",
+		"from_user_id":  claims.UserID,
+		"from_username": claims.Username,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Friend request cancelled",
+	})
+}
+
+// getSentFriendRequestsHandler gets outgoing pending friend requests
+func getSentFriendRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	claims, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	requests, err := friendsService.GetSentFriendRequests(claims.UserID)
+	if err != nil {
+		log.Printf("❌ Error getting sent friend requests: %v", err)
+		http.Error(w, "Failed to get sent friend requests", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(requests)
+}
+
 // Exported handlers for main.go
 
 // FriendsHandler returns handler for getting friends list
@@ -576,3 +926,21 @@ func RejectFriendRequestHandler(service *FriendsService) http.HandlerFunc {
 	friendsService = service
 	return rejectFriendRequestHandler
 }
+
+// RemoveFriendHandler returns handler for removing friends
+func RemoveFriendHandler(service *FriendsService) http.HandlerFunc {
+	friendsService = service
+	return removeFriendHandler
+}
+
+// CancelFriendRequestHandler returns handler for cancelling sent friend requests
+func CancelFriendRequestHandler(service *FriendsService) http.HandlerFunc {
+	friendsService = service
+	return cancelFriendRequestHandler
+}
+
+// GetSentFriendRequestsHandler returns handler for listing sent friend requests
+func GetSentFriendRequestsHandler(service *FriendsService) http.HandlerFunc {
+	friendsService = service
+	return getSentFriendRequestsHandler
+}
@@ -2,15 +2,22 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"e5realtimechat/internal/auth"
 	"e5realtimechat/internal/database"
 )
 
-// GetMessageHistoryHandler returns chat history between current user and another user
+// GetMessageHistoryHandler returns a page of chat history with another user (user_id)
+// or in a room (room_id). Pages are selected with before_id (scroll back) or
+// after_id (catch up) and has_more tells whether another page exists.
 func GetMessageHistoryHandler(db *database.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// CORS headers
@@ -35,43 +42,286 @@ func GetMessageHistoryHandler(db *database.DB) http.HandlerFunc {
 			return
 		}
 
-		// Get other user ID from query
-		otherUserIDStr := r.URL.Query().Get("user_id")
-		if otherUserIDStr == "" {
-			http.Error(w, "Missing user_id parameter", http.StatusBadRequest)
+		query := r.URL.Query()
+
+		cursor, errMsg := parseHistoryCursor(r)
+		if errMsg != "" {
+			http.Error(w, errMsg, http.StatusBadRequest)
 			return
 		}
 
-		otherUserID, err := strconv.Atoi(otherUserIDStr)
+		var page *database.MessagePage
+		var err error
+
+		if roomIDStr := query.Get("room_id"); roomIDStr != "" {
+			roomID, convErr := strconv.Atoi(roomIDStr)
+			if convErr != nil || roomID <= 0 {
+				http.Error(w, "Invalid room_id", http.StatusBadRequest)
+				return
+			}
+
+			allowed, accessErr := db.CanReadRoom(roomID, userID)
+			if errors.Is(accessErr, database.ErrRoomNotFound) {
+				http.Error(w, "Room not found", http.StatusNotFound)
+				return
+			}
+			if accessErr != nil {
+				log.Printf("❌ Error checking room access: %v", accessErr)
+				http.Error(w, "Failed to get message history", http.StatusInternalServerError)
+				return
+			}
+			if !allowed {
+				http.Error(w, "You are not a member of this room", http.StatusForbidden)
+				return
+			}
+
+			page, err = db.GetRoomMessagePage(roomID, userID, cursor)
+		} else {
+			// Get other user ID from query
+			otherUserIDStr := query.Get("user_id")
+			if otherUserIDStr == "" {
+				http.Error(w, "Missing user_id or room_id parameter", http.StatusBadRequest)
+				return
+			}
+
+			otherUserID, convErr := strconv.Atoi(otherUserIDStr)
+			if convErr != nil {
+				http.Error(w, "Invalid user_id", http.StatusBadRequest)
+				return
+			}
+
+			page, err = db.GetDirectMessagePage(userID, otherUserID, cursor)
+		}
+
 		if err != nil {
-			http.Error(w, "Invalid user_id", http.StatusBadRequest)
+			log.Printf("❌ Error getting message history: %v", err)
+			http.Error(w, "Failed to get message history", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":  true,
+			"messages": page.Messages,
+			"has_more": page.HasMore,
+		})
+	}
+}
+
+// GetThreadHistoryHandler returns the root message of a thread (message_id)
+// and a page of its replies, paginated like the message history
+func GetThreadHistoryHandler(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// CORS headers
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, ok := r.Context().Value(auth.UserIDKey).(int)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
-		// Get limit (default 50)
-		limit := 50
-		if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
-			if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
-				limit = l
+		rootID, err := strconv.Atoi(r.URL.Query().Get("message_id"))
+		if err != nil || rootID <= 0 {
+			http.Error(w, "Invalid message_id", http.StatusBadRequest)
+			return
+		}
+
+		cursor, errMsg := parseHistoryCursor(r)
+		if errMsg != "" {
+			http.Error(w, errMsg, http.StatusBadRequest)
+			return
+		}
+
+		root, ok := getReadableMessage(w, db, rootID, userID)
+		if !ok {
+			return
+		}
+		if root.ThreadRootID != nil {
+			// A reply was given, open the thread it belongs to
+			if root, ok = getReadableMessage(w, db, *root.ThreadRootID, userID); !ok {
+				return
 			}
 		}
 
-		// Get messages from database
-		messages, err := db.GetPrivateMessages(userID, otherUserID, limit)
+		page, err := db.GetThreadPage(root.ID, userID, cursor)
+		if err == nil {
+			err = db.AttachReplyCounts([]*database.Message{root})
+		}
 		if err != nil {
-			log.Printf("❌ Error getting message history: %v", err)
-			http.Error(w, "Failed to get message history", http.StatusInternalServerError)
+			log.Printf("❌ Error getting thread history: %v", err)
+			http.Error(w, "Failed to get thread history", http.StatusInternalServerError)
 			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"success":  true,
-			"messages": messages,
+			"root":     root,
+			"messages": page.Messages,
+			"has_more": page.HasMore,
 		})
 	}
 }
 
+// Search query length bounds, in characters
+const (
+	minSearchQueryLength = 2
+	maxSearchQueryLength = 200
+)
+
+// SearchMessagesHandler searches the caller's direct messages and rooms.
+// Query: q, optional from_user_id, user_id (direct conversation) or room_id,
+// since/until (RFC 3339 or YYYY-MM-DD), before_id and limit.
+func SearchMessagesHandler(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// CORS headers
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, ok := r.Context().Value(auth.UserIDKey).(int)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		query := r.URL.Query()
+		search := database.MessageSearch{
+			Query: strings.TrimSpace(query.Get("q")),
+			Limit: 20,
+		}
+		if n := utf8.RuneCountInString(search.Query); n < minSearchQueryLength || n > maxSearchQueryLength {
+			http.Error(w, fmt.Sprintf("q must be %d to %d characters", minSearchQueryLength, maxSearchQueryLength), http.StatusBadRequest)
+			return
+		}
+
+		for param, dest := range map[string]*int{
+			"from_user_id": &search.FromUserID,
+			"user_id":      &search.WithUserID,
+			"room_id":      &search.RoomID,
+			"before_id":    &search.BeforeID,
+			"limit":        &search.Limit,
+		} {
+			value := query.Get(param)
+			if value == "" {
+				continue
+			}
+			id, err := strconv.Atoi(value)
+			if err != nil || id <= 0 {
+				http.Error(w, "Invalid "+param, http.StatusBadRequest)
+				return
+			}
+			*dest = id
+		}
+		if search.WithUserID > 0 && search.RoomID > 0 {
+			http.Error(w, "Use either user_id or room_id, not both", http.StatusBadRequest)
+			return
+		}
+
+		var err error
+		if search.Since, err = parseSearchTime(query.Get("since"), false); err != nil {
+			http.Error(w, "Invalid since", http.StatusBadRequest)
+			return
+		}
+		if search.Until, err = parseSearchTime(query.Get("until"), true); err != nil {
+			http.Error(w, "Invalid until", http.StatusBadRequest)
+			return
+		}
+
+		page, err := db.SearchMessages(userID, search)
+		if err != nil {
+			log.Printf("❌ Error searching messages: %v", err)
+			http.Error(w, "Failed to search messages", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":  true,
+			"results":  page.Results,
+			"has_more": page.HasMore,
+		})
+	}
+}
+
+// parseSearchTime parses an RFC 3339 timestamp or a YYYY-MM-DD date.
+// A date used as an upper bound includes the whole day.
+func parseSearchTime(value string, endOfDay bool) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, nil
+}
+
+// parseHistoryCursor reads limit, before_id and after_id from the query string
+func parseHistoryCursor(r *http.Request) (database.HistoryCursor, string) {
+	query := r.URL.Query()
+
+	// Get limit (default 50, capped at MaxHistoryLimit)
+	cursor := database.HistoryCursor{Limit: 50}
+	if limitStr := query.Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			cursor.Limit = l
+		}
+	}
+	if cursor.Limit > database.MaxHistoryLimit {
+		cursor.Limit = database.MaxHistoryLimit
+	}
+
+	if beforeStr := query.Get("before_id"); beforeStr != "" {
+		id, err := strconv.Atoi(beforeStr)
+		if err != nil || id <= 0 {
+			return cursor, "Invalid before_id"
+		}
+		cursor.BeforeID = id
+	}
+	if afterStr := query.Get("after_id"); afterStr != "" {
+		id, err := strconv.Atoi(afterStr)
+		if err != nil || id < 0 {
+			return cursor, "Invalid after_id"
+		}
+		cursor.AfterID = id
+	}
+	if cursor.BeforeID > 0 && cursor.AfterID > 0 {
+		return cursor, "Use either before_id or after_id, not both"
+	}
+
+	return cursor, ""
+}
+
 // GetConversationsHandler returns list of conversations for current user
 func GetConversationsHandler(db *database.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -113,6 +363,468 @@ func GetConversationsHandler(db *database.DB) http.HandlerFunc {
 	}
 }
 
+// sendMessageRequest is the payload for sending a message over REST
+type sendMessageRequest struct {
+	ToUserID     int    `json:"to_user_id"`
+	RoomID       int    `json:"room_id"`
+	Text         string `json:"text"`
+	ClientMsgID  string `json:"client_msg_id"`  // optional, retries with the same ID return the original message
+	ReplyToID    int    `json:"reply_to_id"`    // optional, quoted message
+	ThreadRootID int    `json:"thread_root_id"` // optional, post in this message's thread
+}
+
+// SendMessageHandler persists a direct or room message and delivers it in realtime.
+// It is the REST equivalent of a WebSocket "message" frame.
+func SendMessageHandler(db *database.DB, hub MessagesHubInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// CORS headers
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		claims, ok := auth.GetUserFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req sendMessageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if req.Text == "" {
+			http.Error(w, "text is required", http.StatusBadRequest)
+			return
+		}
+		if (req.ToUserID > 0) == (req.RoomID > 0) {
+			http.Error(w, "Exactly one of to_user_id or room_id is required", http.StatusBadRequest)
+			return
+		}
+		if len(req.ClientMsgID) > database.MaxClientMsgIDLength {
+			http.Error(w, "client_msg_id is too long", http.StatusBadRequest)
+			return
+		}
+
+		msg := &database.Message{
+			Type:        "message",
+			FromUserID:  claims.UserID,
+			Text:        req.Text,
+			ClientMsgID: req.ClientMsgID,
+		}
+		msg.SetReply(req.ReplyToID, req.ThreadRootID)
+
+		if req.RoomID > 0 {
+			if _, err := db.GetRoomByID(req.RoomID); err != nil {
+				if errors.Is(err, database.ErrRoomNotFound) {
+					http.Error(w, "Room not found", http.StatusNotFound)
+					return
+				}
+				log.Printf("❌ Error getting room: %v", err)
+				http.Error(w, "Failed to send message", http.StatusInternalServerError)
+				return
+			}
+			if err := db.CheckRoomPostPermission(req.RoomID, claims.UserID); err != nil {
+				if errors.Is(err, database.ErrNotRoomMember) || errors.Is(err, database.ErrRoomBanned) || errors.Is(err, database.ErrRoomMuted) {
+					http.Error(w, "Cannot send to room: "+err.Error(), http.StatusForbidden)
+					return
+				}
+				log.Printf("❌ Error checking room permissions: %v", err)
+				http.Error(w, "Failed to send message", http.StatusInternalServerError)
+				return
+			}
+			msg.RoomID = &req.RoomID
+		} else {
+			msg.ToUserID = &req.ToUserID
+		}
+
+		if err := db.SaveMessage(msg); err != nil {
+			if errors.Is(err, database.ErrNotFriends) {
+				http.Error(w, "You can only send direct messages to friends", http.StatusForbidden)
+				return
+			}
+			if errors.Is(err, database.ErrInvalidReply) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			log.Printf("❌ Error saving message: %v", err)
+			http.Error(w, "Failed to send message", http.StatusInternalServerError)
+			return
+		}
+
+		status := http.StatusOK
+		if !msg.Duplicate {
+			status = http.StatusCreated
+			if hub != nil {
+				hub.DeliverMessage(msg, claims.Username)
+			}
+		}
+
+		respondJSON(w, status, map[string]interface{}{
+			"success":   true,
+			"message":   msg,
+			"duplicate": msg.Duplicate,
+		})
+	}
+}
+
+// MessagesHubInterface defines methods needed from websocket Hub for messages
+type MessagesHubInterface interface {
+	DeliverMessage(msg *database.Message, fromUsername string)
+	SendReadReceipt(receipt *database.ReadReceipt)
+	NotifyMessageEdited(msg *database.Message)
+	NotifyMessageDeleted(msg *database.Message)
+	NotifyMessageHidden(msg *database.Message, userID int)
+}
+
+// markReadRequest is the payload for marking a conversation as read
+type markReadRequest struct {
+	UserID    int `json:"user_id"`    // the other user of the conversation
+	MessageID int `json:"message_id"` // newest message the caller has seen
+}
+
+// MarkReadHandler marks messages from another user as read up to a message ID
+// and sends a realtime receipt to that user
+func MarkReadHandler(db *database.DB, hub MessagesHubInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// CORS headers
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Get current user from context
+		userID, ok := r.Context().Value(auth.UserIDKey).(int)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req markReadRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID <= 0 || req.MessageID <= 0 {
+			http.Error(w, "user_id and message_id are required", http.StatusBadRequest)
+			return
+		}
+
+		// Blocking keeps the messages, but the blocker must not get receipts
+		blocked, err := db.IsBlocked(userID, req.UserID)
+		if err != nil {
+			log.Printf("❌ Error checking block: %v", err)
+			http.Error(w, "Failed to mark messages as read", http.StatusInternalServerError)
+			return
+		}
+		if blocked {
+			http.Error(w, "You cannot message this user", http.StatusForbidden)
+			return
+		}
+
+		receipt, err := db.MarkConversationRead(userID, req.UserID, req.MessageID)
+		if err != nil {
+			log.Printf("❌ Error marking messages as read: %v", err)
+			http.Error(w, "Failed to mark messages as read", http.StatusInternalServerError)
+			return
+		}
+
+		if hub != nil {
+			hub.SendReadReceipt(receipt)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"receipt": receipt,
+		})
+	}
+}
+
+// editMessageRequest is the payload for editing a message
+type editMessageRequest struct {
+	MessageID int    `json:"message_id"`
+	Text      string `json:"text"`
+}
+
+// EditMessageHandler lets the author change the text of a recent message
+func EditMessageHandler(db *database.DB, hub MessagesHubInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// CORS headers
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, PUT, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, ok := r.Context().Value(auth.UserIDKey).(int)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req editMessageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MessageID <= 0 || req.Text == "" {
+			http.Error(w, "message_id and text are required", http.StatusBadRequest)
+			return
+		}
+
+		// Unknown messages are reported by EditMessage below
+		if msg, err := db.GetMessageByID(req.MessageID); err == nil && msg.RoomID == nil && msg.ToUserID != nil {
+			peerID := msg.FromUserID
+			if peerID == userID {
+				peerID = *msg.ToUserID
+			}
+			blocked, err := db.IsBlocked(userID, peerID)
+			if err != nil {
+				log.Printf("❌ Error checking block: %v", err)
+				http.Error(w, "Failed to edit message", http.StatusInternalServerError)
+				return
+			}
+			if blocked {
+				http.Error(w, "You cannot message this user", http.StatusForbidden)
+				return
+			}
+		}
+
+		msg, err := db.EditMessage(req.MessageID, userID, req.Text)
+		switch {
+		case errors.Is(err, database.ErrMessageNotFound):
+			http.Error(w, "Message not found", http.StatusNotFound)
+			return
+		case errors.Is(err, database.ErrNotMessageAuthor), errors.Is(err, database.ErrEditWindowExpired):
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		case errors.Is(err, database.ErrNotRoomMember), errors.Is(err, database.ErrRoomBanned), errors.Is(err, database.ErrRoomMuted):
+			http.Error(w, "Cannot edit message: "+err.Error(), http.StatusForbidden)
+			return
+		case errors.Is(err, database.ErrMessageUnchanged):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, database.ErrMessageDeleted):
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		case err != nil:
+			log.Printf("❌ Error editing message: %v", err)
+			http.Error(w, "Failed to edit message", http.StatusInternalServerError)
+			return
+		}
+
+		if hub != nil {
+			hub.NotifyMessageEdited(msg)
+		}
+
+		respondJSON(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"message": msg,
+		})
+	}
+}
+
+// MessageEditsHandler returns the previous versions of a message
+func MessageEditsHandler(db *database.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// CORS headers
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, ok := r.Context().Value(auth.UserIDKey).(int)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		messageID, err := strconv.Atoi(r.URL.Query().Get("message_id"))
+		if err != nil || messageID <= 0 {
+			http.Error(w, "Invalid message_id", http.StatusBadRequest)
+			return
+		}
+
+		msg, ok := getReadableMessage(w, db, messageID, userID)
+		if !ok {
+			return
+		}
+		if msg.DeletedAt != nil {
+			// Previous versions are retracted along with the message
+			http.Error(w, database.ErrMessageDeleted.Error(), http.StatusGone)
+			return
+		}
+
+		edits, err := db.GetMessageEdits(msg.ID)
+		if err != nil {
+			log.Printf("❌ Error getting message edits: %v", err)
+			http.Error(w, "Failed to get message edits", http.StatusInternalServerError)
+			return
+		}
+		if edits == nil {
+			edits = []*database.MessageEdit{}
+		}
+
+		respondJSON(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"message": msg,
+			"edits":   edits,
+		})
+	}
+}
+
+// Deletion scopes
+const (
+	DeleteScopeMe       = "me"       // hide the message for the caller only
+	DeleteScopeEveryone = "everyone" // retract the message for all participants
+)
+
+// deleteMessageRequest is the body of POST/DELETE /api/messages/delete
+type deleteMessageRequest struct {
+	MessageID int    `json:"message_id"`
+	Scope     string `json:"scope"` // "me" or "everyone" (default)
+}
+
+// DeleteMessageHandler deletes a message for the caller only (scope "me") or
+// retracts it for everyone (author, or room moderators and admins)
+func DeleteMessageHandler(db *database.DB, hub MessagesHubInterface) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// CORS headers
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, ok := r.Context().Value(auth.UserIDKey).(int)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req deleteMessageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MessageID <= 0 {
+			http.Error(w, "message_id is required", http.StatusBadRequest)
+			return
+		}
+		if req.Scope == "" {
+			req.Scope = DeleteScopeEveryone
+		}
+
+		switch req.Scope {
+		case DeleteScopeMe:
+			msg, ok := getReadableMessage(w, db, req.MessageID, userID)
+			if !ok {
+				return
+			}
+			if err := db.HideMessage(msg.ID, userID); err != nil {
+				log.Printf("❌ Error hiding message: %v", err)
+				http.Error(w, "Failed to delete message", http.StatusInternalServerError)
+				return
+			}
+			if hub != nil {
+				hub.NotifyMessageHidden(msg, userID)
+			}
+
+		case DeleteScopeEveryone:
+			msg, err := db.DeleteMessage(req.MessageID, userID)
+			switch {
+			case errors.Is(err, database.ErrMessageNotFound):
+				http.Error(w, "Message not found", http.StatusNotFound)
+				return
+			case errors.Is(err, database.ErrCannotDeleteMessage):
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			case errors.Is(err, database.ErrMessageDeleted):
+				http.Error(w, err.Error(), http.StatusGone)
+				return
+			case err != nil:
+				log.Printf("❌ Error deleting message: %v", err)
+				http.Error(w, "Failed to delete message", http.StatusInternalServerError)
+				return
+			}
+			if hub != nil {
+				hub.NotifyMessageDeleted(msg)
+			}
+
+		default:
+			http.Error(w, "scope must be \"me\" or \"everyone\"", http.StatusBadRequest)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, map[string]interface{}{
+			"success":    true,
+			"message_id": req.MessageID,
+			"scope":      req.Scope,
+		})
+	}
+}
+
+// getReadableMessage loads a message and checks the user belongs to its conversation.
+// It writes the error response and returns false when the message can't be read.
+func getReadableMessage(w http.ResponseWriter, db *database.DB, messageID, userID int) (*database.Message, bool) {
+	msg, err := db.GetMessageByID(messageID)
+	if errors.Is(err, database.ErrMessageNotFound) {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("❌ Error getting message: %v", err)
+		http.Error(w, "Failed to get message", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	allowed, err := db.CanSeeMessage(msg, userID)
+	if err != nil {
+		log.Printf("❌ Error checking room access: %v", err)
+		http.Error(w, "Failed to get message", http.StatusInternalServerError)
+		return nil, false
+	}
+	if !allowed {
+		// Don't reveal messages of other conversations
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return nil, false
+	}
+	return msg, true
+}
+
 var dbInstance *database.DB
 
 // SetDBInstance sets the database instance for SaveMessageToDB
@@ -121,13 +833,19 @@ func SetDBInstance(db *database.DB) {
 }
 
 // SaveMessageToDB saves a message to database (called from WebSocket handler)
-func SaveMessageToDB(fromUserID, toUserID int, messageText string) error {
+// and returns it with the server-assigned ID and timestamp
+func SaveMessageToDB(fromUserID, toUserID int, messageText, clientMsgID string, replyToID, threadRootID int) (*database.Message, error) {
 	msg := &database.Message{
-		Type:       "message",
-		FromUserID: fromUserID,
-		ToUserID:   &toUserID,
-		Text:       messageText,
+		Type:        "message",
+		FromUserID:  fromUserID,
+		ToUserID:    &toUserID,
+		Text:        messageText,
+		ClientMsgID: clientMsgID,
 	}
+	msg.SetReply(replyToID, threadRootID)
 
-	return dbInstance.SaveMessage(msg)
+	if err := dbInstance.SaveMessage(msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
 }
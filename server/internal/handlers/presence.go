@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"e5realtimechat/internal/auth"
+	"e5realtimechat/internal/cache"
+	"e5realtimechat/internal/database"
+)
+
+// presenceRequest is the payload of a presence mode change
+type presenceRequest struct {
+	Mode string `json:"mode"` // "visible", "invisible" or "dnd"
+}
+
+// presenceHandler gets (GET) or changes (PUT / POST) the caller's presence mode
+func presenceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, PUT, POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	claims, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		mode, err := friendsService.store.GetPresenceMode(claims.UserID)
+		if errors.Is(err, database.ErrUserNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("❌ Error getting presence mode: %v", err)
+			http.Error(w, "Failed to get presence mode", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"mode": mode,
+		})
+
+	case http.MethodPut, http.MethodPost:
+		var req presenceRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		err := friendsService.store.SetPresenceMode(claims.UserID, req.Mode)
+		if errors.Is(err, database.ErrInvalidPresenceMode) {
+			http.Error(w, "mode must be visible, invisible or dnd", http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, database.ErrUserNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("❌ Error setting presence mode: %v", err)
+			http.Error(w, "Failed to set presence mode", http.StatusInternalServerError)
+			return
+		}
+
+		// Let friends and shared-room members see the change right away
+		if friendsService.hub != nil {
+			friendsService.hub.UpdatePresenceMode(claims.UserID, claims.Username, req.Mode)
+		}
+		log.Printf("👁️ User %d presence mode set to %s", claims.UserID, req.Mode)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"mode":    req.Mode,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// devicesHandler lists the caller's live WebSocket connections (tabs, apps)
+// across all server instances
+func devicesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	claims, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Connections are only tracked in Redis
+	devices := []cache.DeviceConnection{}
+	if friendsService.cacheService != nil {
+		var err error
+		devices, err = friendsService.cacheService.GetUserConnections(claims.UserID)
+		if err != nil {
+			log.Printf("❌ Error getting devices: %v", err)
+			http.Error(w, "Failed to get devices", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(devices)
+}
+
+// PresenceHandler returns handler for getting and setting the presence mode
+func PresenceHandler(service *FriendsService) http.HandlerFunc {
+	friendsService = service
+	return presenceHandler
+}
+
+// DevicesHandler returns handler for listing the caller's connected devices
+func DevicesHandler(service *FriendsService) http.HandlerFunc {
+	friendsService = service
+	return devicesHandler
+}
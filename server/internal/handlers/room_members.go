@@ -0,0 +1,350 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"e5realtimechat/internal/auth"
+	"e5realtimechat/internal/database"
+)
+
+// Moderation actions exposed under /api/rooms/members/
+const (
+	ActionInvite  = "invite"
+	ActionKick    = "kick"
+	ActionBan     = "ban"
+	ActionUnban   = "unban"
+	ActionMute    = "mute"
+	ActionUnmute  = "unmute"
+	ActionPromote = "promote"
+	ActionDemote  = "demote"
+)
+
+// maxModerationMinutes caps timed bans and mutes (one year), longer ones are permanent bans
+const maxModerationMinutes = 365 * 24 * 60
+
+// memberActionRequest is the payload for moderation actions
+type memberActionRequest struct {
+	RoomID          int    `json:"room_id"`
+	UserID          int    `json:"user_id"`
+	DurationMinutes int    `json:"duration_minutes,omitempty"` // ban (0 = permanent) and mute
+	Reason          string `json:"reason,omitempty"`
+}
+
+// moderationError carries the HTTP status for a rejected moderation action
+type moderationError struct {
+	status  int
+	message string
+}
+
+func (e *moderationError) Error() string {
+	return e.message
+}
+
+func forbidden(message string) error {
+	return &moderationError{status: http.StatusForbidden, message: message}
+}
+
+func badRequest(message string) error {
+	return &moderationError{status: http.StatusBadRequest, message: message}
+}
+
+// ModerateMember applies a moderation action after checking the caller's role,
+// then broadcasts it to the room as a system event
+func (s *RoomsService) ModerateMember(actorID int, action string, req memberActionRequest) (map[string]interface{}, error) {
+	if req.RoomID <= 0 || req.UserID <= 0 {
+		return nil, badRequest("room_id and user_id are required")
+	}
+	if req.DurationMinutes < 0 || req.DurationMinutes > maxModerationMinutes {
+		return nil, badRequest("duration_minutes must be between 0 and " + strconv.Itoa(maxModerationMinutes))
+	}
+	if req.UserID == actorID {
+		return nil, badRequest("You cannot moderate yourself")
+	}
+
+	if _, err := s.db.GetRoomByID(req.RoomID); err != nil {
+		if errors.Is(err, database.ErrRoomNotFound) {
+			return nil, &moderationError{status: http.StatusNotFound, message: "Room not found"}
+		}
+		return nil, err
+	}
+
+	actorRole, err := s.db.GetRoomMemberRole(req.RoomID, actorID)
+	if err != nil {
+		return nil, err
+	}
+	targetRole, err := s.db.GetRoomMemberRole(req.RoomID, req.UserID)
+	if err != nil {
+		return nil, err
+	}
+	actorRank := database.RoomRoleRank(actorRole)
+	targetRank := database.RoomRoleRank(targetRole)
+
+	// Promote/demote need admin, everything else needs at least moderator
+	requiredRank := database.RoomRoleRank("moderator")
+	if action == ActionPromote || action == ActionDemote {
+		requiredRank = database.RoomRoleRank("admin")
+	}
+	if actorRank < requiredRank {
+		return nil, forbidden("You don't have permission to " + action + " members of this room")
+	}
+	// Moderators can only act on members ranked below them
+	if targetRank >= actorRank && action != ActionPromote && action != ActionDemote {
+		return nil, forbidden("You cannot " + action + " a member with the same or higher role")
+	}
+
+	event := map[string]interface{}{
+		"type":       "room_member_event",
+		"action":     action,
+		"room_id":    req.RoomID,
+		"user_id":    req.UserID,
+		"by_user_id": actorID,
+	}
+
+	var until *time.Time
+	if req.DurationMinutes > 0 {
+		t := time.Now().Add(time.Duration(req.DurationMinutes) * time.Minute)
+		until = &t
+		event["until"] = t
+	}
+
+	switch action {
+	case ActionInvite:
+		if targetRank > 0 {
+			return nil, badRequest("User is already a member")
+		}
+		banned, err := s.db.IsRoomBanned(req.RoomID, req.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if banned {
+			return nil, badRequest("User is banned from this room")
+		}
+		if err := s.db.AddRoomMember(req.RoomID, req.UserID, "member"); err != nil {
+			return nil, err
+		}
+
+	case ActionKick:
+		if targetRank == 0 {
+			return nil, badRequest("User is not a member")
+		}
+		if err := s.db.RemoveRoomMember(req.RoomID, req.UserID); err != nil {
+			return nil, err
+		}
+
+	case ActionBan:
+		if err := s.db.BanRoomMember(req.RoomID, req.UserID, actorID, req.Reason, until); err != nil {
+			return nil, err
+		}
+		if req.Reason != "" {
+			event["reason"] = req.Reason
+		}
+
+	case ActionUnban:
+		err := s.db.UnbanRoomMember(req.RoomID, req.UserID)
+		if errors.Is(err, database.ErrNotBanned) {
+			return nil, badRequest("User is not banned from this room")
+		}
+		if err != nil {
+			return nil, err
+		}
+
+	case ActionMute:
+		if targetRank == 0 {
+			return nil, badRequest("User is not a member")
+		}
+		if until == nil {
+			return nil, badRequest("duration_minutes is required")
+		}
+		if err := s.db.MuteRoomMember(req.RoomID, req.UserID, until); err != nil {
+			return nil, err
+		}
+
+	case ActionUnmute:
+		if targetRank == 0 {
+			return nil, badRequest("User is not a member")
+		}
+		if err := s.db.MuteRoomMember(req.RoomID, req.UserID, nil); err != nil {
+			return nil, err
+		}
+
+	case ActionPromote:
+		var newRole string
+		switch targetRole {
+		case "member":
+			newRole = "moderator"
+		case "moderator":
+			newRole = "admin"
+		case "admin":
+			return nil, badRequest("User is already an admin")
+		default:
+			return nil, badRequest("User is not a member")
+		}
+		if err := s.db.SetRoomMemberRole(req.RoomID, req.UserID, newRole); err != nil {
+			return nil, err
+		}
+		event["role"] = newRole
+
+	case ActionDemote:
+		if targetRole != "moderator" {
+			return nil, badRequest("Only moderators can be demoted")
+		}
+		if err := s.db.SetRoomMemberRole(req.RoomID, req.UserID, "member"); err != nil {
+			return nil, err
+		}
+		event["role"] = "member"
+
+	default:
+		return nil, badRequest("Unknown action")
+	}
+
+	s.broadcastMemberEvent(action, req, event)
+	log.Printf("🛡️ Room %d: user %d %s user %d", req.RoomID, actorID, action, req.UserID)
+	return event, nil
+}
+
+// broadcastMemberEvent evicts removed users and notifies the room and the target
+func (s *RoomsService) broadcastMemberEvent(action string, req memberActionRequest, event map[string]interface{}) {
+	if s.hub == nil {
+		return
+	}
+
+	if action == ActionKick || action == ActionBan {
+		s.hub.RemoveUserFromRoom(req.UserID, req.RoomID)
+	}
+
+	eventBytes, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("⚠️ Failed to marshal member event: %v", err)
+		return
+	}
+	s.hub.SendRoomMessage(eventBytes, req.RoomID)
+
+	// The target may not be subscribed to the room (invited, kicked, banned)
+	s.hub.SendDirectMessage(eventBytes, req.UserID)
+}
+
+// RoomMemberActionHandler returns a handler applying one moderation action
+func RoomMemberActionHandler(service *RoomsService, action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// CORS headers
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, ok := r.Context().Value(auth.UserIDKey).(int)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req memberActionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		event, err := service.ModerateMember(userID, action, req)
+		if err != nil {
+			var modErr *moderationError
+			if errors.As(err, &modErr) {
+				http.Error(w, modErr.message, modErr.status)
+				return
+			}
+			log.Printf("❌ Error applying %s in room %d: %v", action, req.RoomID, err)
+			http.Error(w, "Failed to "+action+" member", http.StatusInternalServerError)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"event":   event,
+		})
+	}
+}
+
+// RoomMembersHandler lists the members of a room with their roles
+func RoomMembersHandler(service *RoomsService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// CORS headers
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, ok := r.Context().Value(auth.UserIDKey).(int)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		roomID, err := strconv.Atoi(r.URL.Query().Get("room_id"))
+		if err != nil || roomID <= 0 {
+			http.Error(w, "Invalid room_id", http.StatusBadRequest)
+			return
+		}
+
+		room, err := service.db.GetRoomByID(roomID)
+		if errors.Is(err, database.ErrRoomNotFound) {
+			http.Error(w, "Room not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("❌ Error getting room: %v", err)
+			http.Error(w, "Failed to get room members", http.StatusInternalServerError)
+			return
+		}
+
+		// Members of private/group rooms are only visible to other members
+		if room.RoomType != "public" {
+			isMember, err := service.db.IsRoomMember(roomID, userID)
+			if err != nil {
+				log.Printf("❌ Error checking room membership: %v", err)
+				http.Error(w, "Failed to get room members", http.StatusInternalServerError)
+				return
+			}
+			if !isMember {
+				http.Error(w, "You are not a member of this room", http.StatusForbidden)
+				return
+			}
+		}
+
+		members, err := service.db.GetRoomMembers(roomID)
+		if err != nil {
+			log.Printf("❌ Error getting room members: %v", err)
+			http.Error(w, "Failed to get room members", http.StatusInternalServerError)
+			return
+		}
+		if members == nil {
+			members = []*database.RoomMember{}
+		}
+
+		respondJSON(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"members": members,
+		})
+	}
+}
@@ -0,0 +1,365 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"e5realtimechat/internal/auth"
+	"e5realtimechat/internal/database"
+)
+
+// Room types supported by the rooms.room_type column
+var validRoomTypes = map[string]bool{
+	"public":  true,
+	"private": true,
+	"group":   true,
+}
+
+const maxRoomNameLength = 100 // characters, rooms.room_name VARCHAR(100)
+
+// RoomHubInterface defines methods needed from websocket Hub for room events
+type RoomHubInterface interface {
+	SendDirectMessage(message []byte, toUserID int)
+	SendRoomMessage(message []byte, roomID int)
+	RemoveUserFromRoom(userID, roomID int)
+	CloseRoom(roomID int, message []byte)
+}
+
+// RoomsService handles room-related operations
+type RoomsService struct {
+	db  *database.DB
+	hub RoomHubInterface // WebSocket hub for realtime room events
+}
+
+// NewRoomsService creates a new rooms service
+func NewRoomsService(db *database.DB) *RoomsService {
+	return &RoomsService{
+		db:  db,
+		hub: nil, // Will be set later
+	}
+}
+
+// SetHub sets the WebSocket hub for realtime room events
+func (s *RoomsService) SetHub(hub RoomHubInterface) {
+	s.hub = hub
+}
+
+// roomRequest is the payload for creating or updating a room
+type roomRequest struct {
+	RoomID      int    `json:"room_id"`
+	RoomName    string `json:"room_name"`
+	RoomType    string `json:"room_type"`
+	Description string `json:"description"`
+}
+
+// validate normalises and checks the room fields
+func (req *roomRequest) validate() string {
+	req.RoomName = strings.TrimSpace(req.RoomName)
+	req.Description = strings.TrimSpace(req.Description)
+	if req.RoomType == "" {
+		req.RoomType = "public"
+	}
+
+	if req.RoomName == "" {
+		return "room_name is required"
+	}
+	if utf8.RuneCountInString(req.RoomName) > maxRoomNameLength {
+		return "room_name is too long"
+	}
+	if !validRoomTypes[req.RoomType] {
+		return "room_type must be public, private or group"
+	}
+	return ""
+}
+
+// notifyRoom pushes a system event to everyone subscribed to a room
+func (s *RoomsService) notifyRoom(roomID int, event map[string]interface{}) {
+	if s.hub == nil {
+		return
+	}
+	event["room_id"] = roomID
+	eventBytes, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("⚠️ Failed to marshal room event: %v", err)
+		return
+	}
+	s.hub.SendRoomMessage(eventBytes, roomID)
+}
+
+// requireRoomAdmin checks that the user is an admin of the room
+func (s *RoomsService) requireRoomAdmin(w http.ResponseWriter, roomID, userID int) bool {
+	role, err := s.db.GetRoomMemberRole(roomID, userID)
+	if err != nil {
+		log.Printf("❌ Error getting room role: %v", err)
+		http.Error(w, "Failed to check permissions", http.StatusInternalServerError)
+		return false
+	}
+	if role != "admin" {
+		http.Error(w, "Only room admins can do this", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// respondJSON writes a JSON response with the given status
+func respondJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+// RoomsHandler lists the caller's rooms (GET) or creates a room (POST)
+func RoomsHandler(service *RoomsService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// CORS headers
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		userID, ok := r.Context().Value(auth.UserIDKey).(int)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			rooms, err := service.db.GetUserRooms(userID)
+			if err != nil {
+				log.Printf("❌ Error getting rooms: %v", err)
+				http.Error(w, "Failed to get rooms", http.StatusInternalServerError)
+				return
+			}
+			if rooms == nil {
+				rooms = []*database.RoomSummary{}
+			}
+			respondJSON(w, http.StatusOK, map[string]interface{}{
+				"success": true,
+				"rooms":   rooms,
+			})
+
+		case http.MethodPost:
+			var req roomRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request", http.StatusBadRequest)
+				return
+			}
+			if msg := req.validate(); msg != "" {
+				http.Error(w, msg, http.StatusBadRequest)
+				return
+			}
+
+			room, err := service.db.CreateRoom(req.RoomName, req.RoomType, req.Description, userID)
+			if errors.Is(err, database.ErrRoomNameTaken) {
+				http.Error(w, "Room name already taken", http.StatusConflict)
+				return
+			}
+			if err != nil {
+				log.Printf("❌ Error creating room: %v", err)
+				http.Error(w, "Failed to create room", http.StatusInternalServerError)
+				return
+			}
+
+			respondJSON(w, http.StatusCreated, map[string]interface{}{
+				"success": true,
+				"room":    room,
+			})
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// UpdateRoomHandler updates a room's name, type or description (room admins only)
+func UpdateRoomHandler(service *RoomsService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// CORS headers
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, PUT, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, ok := r.Context().Value(auth.UserIDKey).(int)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req roomRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RoomID <= 0 {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if msg := req.validate(); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		if !service.requireRoomAdmin(w, req.RoomID, userID) {
+			return
+		}
+
+		room, err := service.db.UpdateRoom(req.RoomID, req.RoomName, req.RoomType, req.Description)
+		switch {
+		case errors.Is(err, database.ErrRoomNameTaken):
+			http.Error(w, "Room name already taken", http.StatusConflict)
+			return
+		case errors.Is(err, database.ErrRoomNotFound):
+			http.Error(w, "Room not found", http.StatusNotFound)
+			return
+		case err != nil:
+			log.Printf("❌ Error updating room: %v", err)
+			http.Error(w, "Failed to update room", http.StatusInternalServerError)
+			return
+		}
+
+		service.notifyRoom(room.ID, map[string]interface{}{
+			"type": "room_updated",
+			"room": room,
+		})
+
+		respondJSON(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"room":    room,
+		})
+	}
+}
+
+// DeleteRoomHandler deletes a room (room admins only)
+func DeleteRoomHandler(service *RoomsService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// CORS headers
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, ok := r.Context().Value(auth.UserIDKey).(int)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req roomRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RoomID <= 0 {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		if !service.requireRoomAdmin(w, req.RoomID, userID) {
+			return
+		}
+
+		if err := service.db.DeleteRoom(req.RoomID); err != nil {
+			if errors.Is(err, database.ErrRoomNotFound) {
+				http.Error(w, "Room not found", http.StatusNotFound)
+				return
+			}
+			log.Printf("❌ Error deleting room: %v", err)
+			http.Error(w, "Failed to delete room", http.StatusInternalServerError)
+			return
+		}
+
+		// Unsubscribe every connection, otherwise the Hub keeps routing to the deleted room
+		if service.hub != nil {
+			eventBytes, _ := json.Marshal(map[string]interface{}{
+				"type":    "room_deleted",
+				"room_id": req.RoomID,
+			})
+			service.hub.CloseRoom(req.RoomID, eventBytes)
+		}
+
+		respondJSON(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"message": "Room deleted",
+		})
+	}
+}
+
+// RoomDirectoryHandler lists public rooms with member counts
+func RoomDirectoryHandler(service *RoomsService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// CORS headers
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, ok := r.Context().Value(auth.UserIDKey).(int)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// Get limit (default 50, max 100) and offset
+		limit := 50
+		if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+			if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+				limit = l
+			}
+		}
+		if limit > 100 {
+			limit = 100
+		}
+		offset := 0
+		if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+			if o, err := strconv.Atoi(offsetStr); err == nil && o > 0 {
+				offset = o
+			}
+		}
+
+		search := strings.TrimSpace(r.URL.Query().Get("q"))
+		rooms, err := service.db.GetRoomDirectory(userID, search, limit, offset)
+		if err != nil {
+			log.Printf("❌ Error getting room directory: %v", err)
+			http.Error(w, "Failed to get room directory", http.StatusInternalServerError)
+			return
+		}
+		if rooms == nil {
+			rooms = []*database.RoomSummary{}
+		}
+
+		respondJSON(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"rooms":   rooms,
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"e5realtimechat/internal/auth"
+	"e5realtimechat/internal/cache"
+	"e5realtimechat/internal/database"
+)
+
+// Custom status errors
+var (
+	ErrInvalidStatus        = errors.New("invalid custom status")
+	ErrStatusAlreadyExpired = errors.New("status expiry must be in the future")
+)
+
+// SetCustomStatus sets the custom status of a user. expiresAt may be nil for
+// a status that doesn't expire. An empty text and emoji clears the status.
+func (s *FriendsService) SetCustomStatus(userID int, text, emoji string, expiresAt *time.Time) error {
+	if utf8.RuneCountInString(text) > database.MaxStatusTextLength || utf8.RuneCountInString(emoji) > database.MaxStatusEmojiLength {
+		return ErrInvalidStatus
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return ErrStatusAlreadyExpired
+	}
+
+	var textArg, emojiArg, expiresArg interface{}
+	if text != "" || emoji != "" {
+		textArg = nullIfEmpty(text)
+		emojiArg = nullIfEmpty(emoji)
+		if expiresAt != nil {
+			// status_expires_at has no time zone, it is compared with NOW() in UTC
+			expiresArg = expiresAt.UTC()
+		}
+	}
+
+	result, err := s.db.Exec(`
+		UPDATE users
+		SET status_text = $2, status_emoji = $3, status_expires_at = $4
+		WHERE id = $1
+	`, userID, textArg, emojiArg, expiresArg)
+	if err != nil {
+		return err
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return auth.ErrUserNotFound
+	}
+
+	if s.cacheService != nil {
+		s.cacheService.InvalidateUserProfile(userID)
+	}
+
+	return nil
+}
+
+// userProfile returns the profile of a user from the profile cache, loading
+// it from the database on a miss, like the hub does for presence
+func (s *FriendsService) userProfile(userID int) (*cache.UserProfile, error) {
+	if s.cacheService != nil {
+		if profile, err := s.cacheService.GetUserProfile(userID); err == nil {
+			return profile, nil
+		}
+	}
+
+	user, err := s.store.GetUserProfile(userID)
+	if err != nil {
+		return nil, err
+	}
+	profile := &cache.UserProfile{
+		ID:              user.ID,
+		Username:        user.Username,
+		AvatarURL:       user.AvatarURL,
+		StatusText:      user.StatusText,
+		StatusEmoji:     user.StatusEmoji,
+		StatusExpiresAt: user.StatusExpiresAt,
+	}
+	if s.cacheService != nil {
+		if err := s.cacheService.SetUserProfile(userID, profile); err != nil {
+			log.Printf("⚠️ Failed to cache profile of user %d: %v", userID, err)
+		}
+	}
+	return profile, nil
+}
+
+// nullIfEmpty stores empty strings as NULL
+func nullIfEmpty(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+// statusRequest is the payload of a custom status change
+type statusRequest struct {
+	Text      string     `json:"text"`
+	Emoji     string     `json:"emoji"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // RFC 3339
+	ExpiresIn int        `json:"expires_in,omitempty"` // seconds, alternative to expires_at
+}
+
+// statusHandler gets (GET), sets (PUT / POST) or clears (DELETE) the caller's custom status
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, PUT, POST, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	claims, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req statusRequest
+	switch r.Method {
+	case http.MethodGet:
+		profile, err := friendsService.userProfile(claims.UserID)
+		if errors.Is(err, database.ErrUserNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("❌ Error getting custom status: %v", err)
+			http.Error(w, "Failed to get status", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status_text":       profile.StatusText,
+			"status_emoji":      profile.StatusEmoji,
+			"status_expires_at": profile.StatusExpiresAt,
+		})
+		return
+
+	case http.MethodPut, http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.Text = strings.TrimSpace(req.Text)
+		req.Emoji = strings.TrimSpace(req.Emoji)
+		if req.ExpiresAt == nil && req.ExpiresIn > 0 {
+			expiresAt := time.Now().Add(time.Duration(req.ExpiresIn) * time.Second)
+			req.ExpiresAt = &expiresAt
+		}
+
+	case http.MethodDelete:
+		// Zero request clears the status
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := friendsService.SetCustomStatus(claims.UserID, req.Text, req.Emoji, req.ExpiresAt)
+	if err == ErrInvalidStatus {
+		http.Error(w, "Status text or emoji is too long", http.StatusBadRequest)
+		return
+	}
+	if err == ErrStatusAlreadyExpired {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err == auth.ErrUserNotFound {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Error setting custom status: %v", err)
+		http.Error(w, "Failed to set status", http.StatusInternalServerError)
+		return
+	}
+
+	// Friends and shared-room members see the new status right away
+	if friendsService.hub != nil {
+		friendsService.hub.NotifyUserStatus(claims.UserID, claims.Username)
+	}
+	log.Printf("💬 User %d custom status set to %q %q", claims.UserID, req.Emoji, req.Text)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
+
+// StatusHandler returns handler for the custom status
+func StatusHandler(service *FriendsService) http.HandlerFunc {
+	friendsService = service
+	return statusHandler
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"e5realtimechat/internal/auth"
+	"e5realtimechat/internal/cache"
+)
+
+// Friend suggestion limits
+const (
+	MaxSuggestionsLimit = 50 // suggestions computed and cached per user
+	mutualFriendPreview = 3  // mutual friends returned with each suggestion
+)
+
+// GetFriendSuggestions suggests users the caller may know, ranked by mutual
+// friends then shared rooms. Friends, pending requests (either direction) and
+// blocks (either direction) are excluded, since each leaves a friendships row.
+func (s *FriendsService) GetFriendSuggestions(userID, limit int) ([]cache.FriendSuggestion, error) {
+	if limit <= 0 || limit > MaxSuggestionsLimit {
+		limit = MaxSuggestionsLimit
+	}
+
+	// Try cache first
+	if s.cacheService != nil {
+		if suggestions, err := s.cacheService.GetFriendSuggestions(userID); err == nil {
+			if len(suggestions) > limit {
+				suggestions = suggestions[:limit]
+			}
+			return suggestions, nil
+		}
+	}
+
+	rows, err := s.db.Query(`
+		WITH my_friends AS (
+			SELECT CASE WHEN user_id = $1 THEN friend_id ELSE user_id END AS id
+			FROM friendships
+			WHERE (user_id = $1 OR friend_id = $1) AND status = 'accepted'
+		),
+		mutual AS (
+			SELECT CASE WHEN f.user_id = mf.id THEN f.friend_id ELSE f.user_id END AS candidate_id,
+			       mf.id AS via_id
+			FROM friendships f
+			INNER JOIN my_friends mf ON f.user_id = mf.id OR f.friend_id = mf.id
+			WHERE f.status = 'accepted'
+		),
+		shared AS (
+			SELECT other.user_id AS candidate_id, COUNT(*) AS shared_rooms
+			FROM room_members mine
+			INNER JOIN room_members other ON other.room_id = mine.room_id AND other.user_id != $1
+			WHERE mine.user_id = $1
+			GROUP BY other.user_id
+		),
+		candidates AS (
+			SELECT candidate_id, COUNT(DISTINCT via_id) AS mutual_count
+			FROM mutual
+			GROUP BY candidate_id
+		)
+		SELECT u.id, u.username, COALESCE(u.avatar_url, ''),
+		       COALESCE(c.mutual_count, 0) AS mutual_count,
+		       COALESCE(sr.shared_rooms, 0) AS shared_rooms,
+		       (SELECT COALESCE(json_agg(json_build_object(
+		                   'id', p.id, 'username', p.username, 'avatar_url', p.avatar_url)), '[]')
+		        FROM (
+		            SELECT DISTINCT pu.id, pu.username, COALESCE(pu.avatar_url, '') AS avatar_url
+		            FROM mutual m
+		            INNER JOIN users pu ON pu.id = m.via_id
+		            WHERE m.candidate_id = u.id
+		            ORDER BY pu.username
+		            LIMIT $3
+		        ) p)
+		FROM users u
+		LEFT JOIN candidates c ON c.candidate_id = u.id
+		LEFT JOIN shared sr ON sr.candidate_id = u.id
+		WHERE (c.candidate_id IS NOT NULL OR sr.candidate_id IS NOT NULL)
+		  AND u.id != $1
+		  AND NOT EXISTS (
+		      SELECT 1 FROM friendships x
+		      WHERE (x.user_id = $1 AND x.friend_id = u.id)
+		         OR (x.user_id = u.id AND x.friend_id = $1)
+		  )
+		ORDER BY mutual_count DESC, shared_rooms DESC, u.username ASC
+		LIMIT $2
+	`, userID, MaxSuggestionsLimit, mutualFriendPreview)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []cache.FriendSuggestion{}
+	for rows.Next() {
+		var suggestion cache.FriendSuggestion
+		var preview []byte
+		if err := rows.Scan(&suggestion.ID, &suggestion.Username, &suggestion.AvatarURL,
+			&suggestion.MutualCount, &suggestion.SharedRooms, &preview); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(preview, &suggestion.MutualFriends); err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, suggestion)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Cache the full list, the requested limit is applied on top of it
+	if s.cacheService != nil {
+		if err := s.cacheService.SetFriendSuggestions(userID, suggestions); err != nil {
+			log.Printf("⚠️ Failed to cache friend suggestions for user %d: %v", userID, err)
+		}
+	}
+
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+	return suggestions, nil
+}
+
+// getFriendSuggestionsHandler lists people the caller may know
+func getFriendSuggestionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	claims, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	limit := 20
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 {
+		limit = l
+	}
+
+	suggestions, err := friendsService.GetFriendSuggestions(claims.UserID, limit)
+	if err != nil {
+		log.Printf("❌ Error getting friend suggestions: %v", err)
+		http.Error(w, "Failed to get friend suggestions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(suggestions)
+}
+
+// GetFriendSuggestionsHandler returns handler for friend suggestions
+func GetFriendSuggestionsHandler(service *FriendsService) http.HandlerFunc {
+	friendsService = service
+	return getFriendSuggestionsHandler
+}
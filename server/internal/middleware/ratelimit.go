@@ -54,6 +54,20 @@ var (
 		BurstSize:         50,  // Increased from 10
 		Window:            time.Minute,
 	}
+
+	// WebSocket typing indicator rate limit (per user, separate from chat messages)
+	WSTypingLimit = RateLimitConfig{
+		RequestsPerMinute: 60,
+		BurstSize:         20,
+		Window:            time.Minute,
+	}
+
+	// WebSocket read receipt rate limit (per user, separate from chat messages)
+	WSReadLimit = RateLimitConfig{
+		RequestsPerMinute: 60,
+		BurstSize:         30,
+		Window:            time.Minute,
+	}
 )
 
 // NewRateLimiter creates a new rate limiter
@@ -182,6 +196,26 @@ func (rl *RateLimiter) CheckUserMessageRate(userID int) (bool, error) {
 	return allowed, nil
 }
 
+// CheckUserTypingRate checks rate limit for typing indicators (for WebSocket)
+func (rl *RateLimiter) CheckUserTypingRate(userID int) (bool, error) {
+	key := fmt.Sprintf("ws:typing:user:%d", userID)
+	allowed, _, _, err := rl.CheckLimit(key, WSTypingLimit)
+	if err != nil {
+		return true, err // Fail open
+	}
+	return allowed, nil
+}
+
+// CheckUserReadRate checks rate limit for read receipts (for WebSocket)
+func (rl *RateLimiter) CheckUserReadRate(userID int) (bool, error) {
+	key := fmt.Sprintf("ws:read:user:%d", userID)
+	allowed, _, _, err := rl.CheckLimit(key, WSReadLimit)
+	if err != nil {
+		return true, err // Fail open
+	}
+	return allowed, nil
+}
+
 // BlockIP temporarily blocks an IP address (for DDoS protection)
 func (rl *RateLimiter) BlockIP(ip string, duration time.Duration) error {
 	key := fmt.Sprintf("blocked:ip:%s", ip)
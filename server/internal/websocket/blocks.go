@@ -0,0 +1,51 @@
+package websocket
+
+import (
+	"log"
+	"time"
+
+	"e5realtimechat/internal/database"
+)
+
+// blockCacheTTL is how long a connection reuses a block check, so a block made
+// over the REST API applies to its other frames within that delay. Chat
+// messages don't rely on it: the messages trigger only accepts direct
+// messages between friends, and blocking removes the friendship.
+const blockCacheTTL = 30 * time.Second
+
+// blockCheck is a cached block check of a connection
+type blockCheck struct {
+	blocked   bool
+	checkedAt time.Time
+}
+
+// blockedWith reports whether the client and another user blocked each other.
+// Only the readPump goroutine touches c.blocks, so no lock is needed.
+func (c *Client) blockedWith(userID int) bool {
+	if c.hub.db == nil || userID <= 0 || userID == c.userID {
+		return false
+	}
+	if check, ok := c.blocks[userID]; ok && time.Since(check.checkedAt) < blockCacheTTL {
+		return check.blocked
+	}
+
+	blocked, err := c.hub.db.IsBlocked(c.userID, userID)
+	if err != nil {
+		log.Printf("⚠️ Failed to check block between %d and %d: %v", c.userID, userID, err)
+		return false
+	}
+	c.blocks[userID] = blockCheck{blocked: blocked, checkedAt: time.Now()}
+	return blocked
+}
+
+// messagePeer returns the other user of a direct message, 0 for room messages.
+// Frames that reference a message instead of a user are checked against it.
+func (c *Client) messagePeer(msg *database.Message) int {
+	if msg.RoomID != nil || msg.ToUserID == nil {
+		return 0
+	}
+	if msg.FromUserID == c.userID {
+		return *msg.ToUserID
+	}
+	return msg.FromUserID
+}
@@ -7,8 +7,11 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 
+	"e5realtimechat/internal/database"
+
 	ws "github.com/gorilla/websocket"
 )
 
@@ -34,19 +37,51 @@ var (
 	space   = []byte{' '}
 )
 
+// clientFrameTypes are the frames a client may send besides heartbeats, room
+// subscriptions, typing and read receipts, which are handled first. Anything
+// else would be relayed as a system event (room_deleted, reaction_added...),
+// so it is rejected.
+var clientFrameTypes = map[string]bool{
+	"message":        true,
+	"edit_message":   true,
+	"delete_message": true,
+	"react":          true,
+	"unreact":        true,
+	"join":           true, // announcements relayed as is
+	"leave":          true,
+}
+
 // Định nghĩa struct Client
 // Một Client đại diện cho một kết nối websocket tới một user cụ thể
 // Nó sẽ đọc tin nhắn từ kết nối và gửi tin nhắn từ Hub xuống kết nối
 type Client struct {
-	hub      *Hub        // tham chiếu tới Hub (quản lý chung)
-	conn     *ws.Conn    // kết nối websocket thật sự
-	send     chan []byte // kênh để nhận tin nhắn từ Hub và gửi xuống client
-	userID   int         // ID của user đang kết nối
-	username string      // Tên của user đang kết nối
+	hub      *Hub          // tham chiếu tới Hub (quản lý chung)
+	conn     *ws.Conn      // kết nối websocket thật sự
+	send     chan []byte   // kênh để nhận tin nhắn từ Hub và gửi xuống client
+	done     chan struct{} // Hub đóng khi bỏ client (session bị thu hồi, buffer đầy)
+	userID   int           // ID của user đang kết nối
+	username string        // Tên của user đang kết nối
+
+	connID      string    // ID của kết nối, duy nhất trong instance
+	sessionID   int       // session đăng nhập (user_sessions) của token
+	device      string    // thiết bị / trình duyệt của kết nối
+	connectedAt time.Time // thời điểm kết nối
+
+	mu       sync.Mutex   // bảo vệ rooms, released (đọc từ readPump, Hub goroutine, reconciler)
+	rooms    map[int]bool // các room client đã join
+	released bool         // kết nối đã bị xóa khỏi Redis, reconciler không được ghi lại
+
+	typing map[typingTarget]time.Time // các cuộc trò chuyện đang gõ (chỉ dùng trong readPump)
+	blocks map[int]blockCheck         // trạng thái chặn đã kiểm tra theo user (chỉ dùng trong readPump)
+
+	// trạng thái resume (chỉ dùng trong Hub goroutine)
+	resumeFrom int64    // last_seq client gửi khi kết nối lại (-1 = phiên mới)
+	syncing    bool     // đang replay, giữ lại các frame trực tiếp mới
+	pending    [][]byte // các frame nhận được trong lúc replay
 }
 
 // SaveMessageFunc is a function type for saving messages to database
-type SaveMessageFunc func(fromUserID, toUserID int, messageText string) error
+type SaveMessageFunc func(fromUserID, toUserID int, messageText, clientMsgID string, replyToID, threadRootID int) (*database.Message, error)
 
 var saveMessageToDB SaveMessageFunc
 
@@ -63,6 +98,7 @@ func SetSaveMessageFunc(fn SaveMessageFunc) {
 func (c *Client) readPump() {
 	defer func() {
 		log.Printf("🔌 Client %d (%s) readPump() exiting", c.userID, c.username)
+		c.stopAllTyping()     // peers must not see a stale typing indicator
 		c.hub.unregister <- c // thông báo Hub biết client rời đi
 		c.conn.Close()
 	}()
@@ -117,7 +153,7 @@ func (c *Client) readPump() {
 				}
 				if ackBytes, err := json.Marshal(ackMsg); err == nil {
 					log.Printf("📤 Sending heartbeat_ack to client %d", c.userID)
-					c.send <- ackBytes
+					c.queue(ackBytes)
 				} else {
 					log.Printf("❌ Failed to marshal heartbeat_ack: %v", err)
 				}
@@ -125,6 +161,38 @@ func (c *Client) readPump() {
 				continue // Don't process further
 			}
 
+			// Handle room subscriptions
+			if wsMsg.Type == "join_room" || wsMsg.Type == "leave_room" {
+				c.handleRoomSubscription(wsMsg)
+				continue
+			}
+
+			// Handle typing indicators (own rate limit, not counted as chat messages)
+			if wsMsg.Type == "typing_start" || wsMsg.Type == "typing_stop" {
+				c.handleTypingFrame(wsMsg)
+				continue
+			}
+
+			// Handle read receipts (own rate limit, each one writes to the database)
+			if wsMsg.Type == "read" {
+				c.handleReadFrame(wsMsg)
+				continue
+			}
+
+			if !clientFrameTypes[wsMsg.Type] {
+				log.Printf("🚫 Client %d (%s) sent unsupported frame type %q", c.userID, c.username, wsMsg.Type)
+				c.sendError("Unsupported frame type: " + wsMsg.Type)
+				continue
+			}
+
+			// Direct frames between users who blocked each other are rejected.
+			// Chat messages are checked by the messages trigger when saved.
+			if wsMsg.ToUserID > 0 && wsMsg.Type != "message" && c.blockedWith(wsMsg.ToUserID) {
+				log.Printf("🚫 Client %d (%s) %s frame to user %d rejected: blocked", c.userID, c.username, wsMsg.Type, wsMsg.ToUserID)
+				c.sendError("You cannot message this user")
+				continue
+			}
+
 			// Check rate limit for this user
 			if c.hub.rateLimiter != nil && c.userID > 0 {
 				log.Printf("🔍 Checking rate limit for user %d...", c.userID)
@@ -133,6 +201,10 @@ func (c *Client) readPump() {
 					log.Printf("⚠️ Rate limit check error for user %d: %v", c.userID, err)
 				} else if !allowed {
 					log.Printf("🚫 Rate limit exceeded for user %d (%s)", c.userID, c.username)
+					if wsMsg.Type == "message" {
+						c.sendNack(wsMsg, "Rate limit exceeded. Please slow down.")
+						continue
+					}
 					// Send rate limit error back to client
 					errorMsg := WSMessage{
 						Type:       "error",
@@ -141,7 +213,7 @@ func (c *Client) readPump() {
 						From:       "System",
 					}
 					if errBytes, err := json.Marshal(errorMsg); err == nil {
-						c.send <- errBytes
+						c.queue(errBytes)
 					}
 					continue // Skip this message
 				} else {
@@ -149,6 +221,20 @@ func (c *Client) readPump() {
 				}
 			}
 
+			// Handle message edits, deletions and reactions (counted against the message rate limit)
+			if wsMsg.Type == "edit_message" {
+				c.handleEditMessage(wsMsg)
+				continue
+			}
+			if wsMsg.Type == "delete_message" {
+				c.handleDeleteMessage(wsMsg)
+				continue
+			}
+			if wsMsg.Type == "react" || wsMsg.Type == "unreact" {
+				c.handleReactionFrame(wsMsg)
+				continue
+			}
+
 			// Add sender info
 			log.Printf("➕ Adding sender info: userID=%d, username=%s", c.userID, c.username)
 			wsMsg.FromUserID = c.userID
@@ -163,22 +249,22 @@ func (c *Client) readPump() {
 				log.Printf("❌ Failed to enhance message: %v", err)
 			}
 
-			// Save message to database if it's a chat message
-			if wsMsg.Type == "message" && wsMsg.Text != "" {
-				log.Printf("💾 Message type is 'message', checking if should save to DB...")
-				if wsMsg.ToUserID > 0 {
-					log.Printf("💾 Saving private message to DB: from=%d, to=%d, text=%s", c.userID, wsMsg.ToUserID, wsMsg.Text)
-					// Private message - save to database
-					if saveMessageToDB != nil {
-						if err := saveMessageToDB(c.userID, wsMsg.ToUserID, wsMsg.Text); err != nil {
-							log.Printf("❌ Error saving message to DB: %v", err)
-						} else {
-							log.Printf("✅ Message saved to DB successfully")
-						}
-					} else {
-						log.Printf("⚠️ saveMessageToDB is nil, cannot save to DB")
-					}
+			// Chat messages are persisted before delivery and acknowledged
+			if wsMsg.Type == "message" {
+				c.handleChatMessage(wsMsg)
+				continue
+			}
+
+			// Check if this is a room message
+			if wsMsg.RoomID > 0 && wsMsg.ToUserID == 0 {
+				if err := c.checkRoomPost(wsMsg.RoomID); err != nil {
+					log.Printf("🚫 Client %d (%s) frame to room %d rejected: %v", c.userID, c.username, wsMsg.RoomID, err)
+					c.sendError("Cannot send to room: " + err.Error())
+					continue
 				}
+				log.Printf("🏠 ROOM MESSAGE detected: from user %d to room %d", c.userID, wsMsg.RoomID)
+				c.hub.SendRoomMessage(message, wsMsg.RoomID)
+				continue
 			}
 
 			// Check if this is a direct message
@@ -189,7 +275,7 @@ func (c *Client) readPump() {
 				c.hub.SendDirectMessage(message, wsMsg.ToUserID)
 				log.Printf("📤 Sending message back to sender (confirmation)...")
 				// Also send back to sender for confirmation
-				c.send <- message
+				c.queue(message)
 				log.Printf("✅ Message sent to recipient (%d) and sender (%d)", wsMsg.ToUserID, c.userID)
 			} else {
 				log.Printf("📡 BROADCAST MESSAGE detected (no specific recipient)")
@@ -204,16 +290,64 @@ func (c *Client) readPump() {
 			// Continue to next iteration
 		} else {
 			log.Printf("❌ Failed to parse message JSON: %v. Raw: %s", err, string(message))
-			// If parse fails, broadcast via Redis
-			if err := c.hub.BroadcastViaRedis(message); err != nil {
-				log.Printf("⚠️ Failed to broadcast via Redis: %v", err)
-			}
+			c.sendError("Invalid frame")
 		}
 		// Loop will automatically continue to wait for next message
 		log.Printf("🔁 End of message processing, looping back...")
 	}
 }
 
+// handleRoomSubscription processes join_room / leave_room frames
+func (c *Client) handleRoomSubscription(wsMsg WSMessage) {
+	if wsMsg.RoomID <= 0 {
+		c.sendError("Missing room_id")
+		return
+	}
+
+	if wsMsg.Type == "leave_room" {
+		c.stopTyping(typingTarget{roomID: wsMsg.RoomID})
+		c.leaveRoom(wsMsg.RoomID)
+		c.sendFrame(WSMessage{Type: "room_left", RoomID: wsMsg.RoomID})
+		return
+	}
+
+	if err := c.joinRoom(wsMsg.RoomID); err != nil {
+		log.Printf("⚠️ Client %d (%s) failed to join room %d: %v", c.userID, c.username, wsMsg.RoomID, err)
+		c.sendError("Cannot join room: " + err.Error())
+		return
+	}
+	c.sendFrame(WSMessage{Type: "room_joined", RoomID: wsMsg.RoomID})
+}
+
+// sendFrame encodes a frame and queues it for this client only
+func (c *Client) sendFrame(frame interface{}) {
+	frameBytes, err := json.Marshal(frame)
+	if err != nil {
+		log.Printf("❌ Failed to marshal frame for client %d: %v", c.userID, err)
+		return
+	}
+	c.queue(frameBytes)
+}
+
+// queue hands a frame from readPump to writePump. Once the Hub dropped the
+// client nobody drains send anymore, so the frame is discarded instead.
+func (c *Client) queue(frame []byte) {
+	select {
+	case c.send <- frame:
+	case <-c.done:
+	}
+}
+
+// sendError sends a system error frame back to this client
+func (c *Client) sendError(text string) {
+	c.sendFrame(WSMessage{
+		Type:       "error",
+		Text:       text,
+		FromUserID: 0,
+		From:       "System",
+	})
+}
+
 // Hàm writePump() – Gửi tin nhắn tới Client
 // Hàm này chạy ở 1 goroutine riêng. Nó:
 // Liên tục lắng nghe kênh c.send để gửi tin nhắn tới client.
@@ -253,6 +387,17 @@ func (c *Client) writePump() {
 				return
 			}
 
+		case <-c.done:
+			// Hub đã bỏ client: gửi nốt các frame còn trong queue rồi đóng kết nối
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			for n := len(c.send); n > 0; n-- {
+				if err := c.conn.WriteMessage(ws.TextMessage, <-c.send); err != nil {
+					return
+				}
+			}
+			c.conn.WriteMessage(ws.CloseMessage, []byte{})
+			return
+
 		case <-ticker.C:
 			// Gửi ping để giữ kết nối
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
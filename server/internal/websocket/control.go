@@ -0,0 +1,161 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// Control actions exchanged between instances on redisControlChannel
+const (
+	controlRoomEvict  = "room_evict" // unsubscribe a user's connections from a room (userID 0 = everyone)
+	controlMulticast  = "multicast"  // send a frame to the connections of some users
+	controlDisconnect = "disconnect" // close the connections of a revoked session
+)
+
+// controlCommand asks every instance to change the state of local connections
+type controlCommand struct {
+	action  string
+	userID  int
+	roomID  int
+	payload json.RawMessage // frame of controlMulticast, last frame of controlRoomEvict
+	userIDs []int           // recipients of controlMulticast
+	session int             // session closed by controlDisconnect (0 = all of the user's)
+}
+
+// publishControl applies a control command locally and forwards it to other instances
+func (h *Hub) publishControl(cmd *controlCommand) {
+	h.applyControlLocal(cmd)
+
+	if h.redisClient == nil {
+		return
+	}
+
+	envelope, err := json.Marshal(redisEnvelope{
+		Origin:   h.instanceID,
+		Action:   cmd.action,
+		ToUserID: cmd.userID,
+		RoomID:   cmd.roomID,
+		Payload:  cmd.payload,
+		UserIDs:  cmd.userIDs,
+		Session:  cmd.session,
+	})
+	if err != nil {
+		log.Printf("⚠️ Failed to marshal control envelope: %v", err)
+		return
+	}
+	if err := h.redisClient.Publish(redisControlChannel, string(envelope)); err != nil {
+		log.Printf("⚠️ Failed to publish control command %s: %v", cmd.action, err)
+	}
+}
+
+// applyControlLocal queues a control command for the Hub goroutine. It never
+// waits for the Hub: controlLoop takes the command right away, so the Redis
+// subscriber keeps delivering the other channels while the Hub is busy.
+func (h *Hub) applyControlLocal(cmd *controlCommand) {
+	h.controlIn <- cmd
+}
+
+// maxQueuedMulticasts caps the multicast commands waiting for the Hub. They
+// carry short-lived frames (typing, receipts, reactions, presence), so past
+// this backlog they are dropped rather than queued.
+const maxQueuedMulticasts = 1024
+
+// controlLoop hands the queued control commands to the Hub goroutine in
+// order. Evictions and disconnects must not be lost, so they are always
+// queued; multicasts are dropped once maxQueuedMulticasts are waiting.
+func (h *Hub) controlLoop() {
+	var queue []*controlCommand
+	multicasts := 0
+	for {
+		var out chan *controlCommand
+		var next *controlCommand
+		if len(queue) > 0 {
+			out, next = h.control, queue[0]
+		}
+		select {
+		case cmd := <-h.controlIn:
+			if cmd.action == controlMulticast {
+				if multicasts >= maxQueuedMulticasts {
+					log.Printf("⚠️ Control queue full, multicast to %d user(s) dropped", len(cmd.userIDs))
+					continue
+				}
+				multicasts++
+			}
+			queue = append(queue, cmd)
+		case out <- next:
+			if next.action == controlMulticast {
+				multicasts--
+			}
+			queue[0] = nil
+			queue = queue[1:]
+		}
+	}
+}
+
+// handleControl executes a control command (must run on the Hub goroutine)
+func (h *Hub) handleControl(cmd *controlCommand) {
+	switch cmd.action {
+	case controlRoomEvict:
+		for client := range h.rooms[cmd.roomID] {
+			if cmd.userID != 0 && client.userID != cmd.userID {
+				continue
+			}
+			// Sent on the same goroutine as the eviction, so it can't race it
+			if cmd.payload != nil && !h.sendToClient(client, cmd.payload) {
+				continue
+			}
+			client.mu.Lock()
+			delete(client.rooms, cmd.roomID)
+			client.mu.Unlock()
+			h.removeFromRoom(client, cmd.roomID)
+			log.Printf("🚫 Client %d (%s) evicted from room %d", client.userID, client.username, cmd.roomID)
+		}
+	case controlMulticast:
+		recipients := make(map[int]bool, len(cmd.userIDs))
+		for _, userID := range cmd.userIDs {
+			recipients[userID] = true
+		}
+		for client := range h.clients {
+			if recipients[client.userID] {
+				h.sendToClient(client, cmd.payload)
+			}
+		}
+	case controlDisconnect:
+		frame, _ := json.Marshal(WSMessage{Type: "session_revoked", UserID: cmd.userID})
+		for client := range h.clients {
+			if client.userID != cmd.userID || (cmd.session != 0 && client.sessionID != cmd.session) {
+				continue
+			}
+			// writePump flushes the frame before closing the connection
+			if h.sendToClient(client, frame) {
+				h.dropClient(client)
+			}
+			log.Printf("🔒 Client %d (%s) disconnected, session %d revoked", client.userID, client.username, client.sessionID)
+		}
+	default:
+		log.Printf("⚠️ Unknown control action: %s", cmd.action)
+	}
+}
+
+// RemoveUserFromRoom unsubscribes every connection of a user from a room, on all instances
+func (h *Hub) RemoveUserFromRoom(userID, roomID int) {
+	h.publishControl(&controlCommand{action: controlRoomEvict, userID: userID, roomID: roomID})
+}
+
+// CloseRoom sends a last frame to every connection subscribed to a room and
+// unsubscribes them, on all instances (the room is gone)
+func (h *Hub) CloseRoom(roomID int, message []byte) {
+	h.publishControl(&controlCommand{action: controlRoomEvict, roomID: roomID, payload: message})
+}
+
+// DisconnectSession closes the connections of a revoked login session on all
+// instances (sessionID 0 = every session of the user)
+func (h *Hub) DisconnectSession(userID, sessionID int) {
+	h.publishControl(&controlCommand{action: controlDisconnect, userID: userID, session: sessionID})
+}
+
+// multicast sends a frame to every connection of the given users, on all instances.
+// Unlike SendDirectMessage the frame is not sequenced, so it is never replayed.
+func (h *Hub) multicast(message []byte, userIDs []int) {
+	h.publishControl(&controlCommand{action: controlMulticast, payload: message, userIDs: userIDs})
+}
@@ -0,0 +1,186 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"e5realtimechat/internal/database"
+)
+
+// deliveryAck tells the sending connection whether a chat message was accepted.
+// TempID is the client's own ID for the pending message, MessageID/CreatedAt
+// are the persisted messages.id and created_at.
+type deliveryAck struct {
+	Type        string     `json:"type"` // "ack" or "nack"
+	TempID      string     `json:"temp_id,omitempty"`
+	ClientMsgID string     `json:"client_msg_id,omitempty"`
+	MessageID   int        `json:"message_id,omitempty"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+	ToUserID    int        `json:"to_user_id,omitempty"`
+	RoomID      int        `json:"room_id,omitempty"`
+	Duplicate   bool       `json:"duplicate,omitempty"` // retry of an already persisted message
+	Error       string     `json:"error,omitempty"`
+}
+
+// handleChatMessage persists a chat message and only then delivers it.
+// The sender always gets an ack or a nack carrying its temp_id.
+func (c *Client) handleChatMessage(wsMsg WSMessage) {
+	if wsMsg.Text == "" {
+		c.sendNack(wsMsg, "Message text is required")
+		return
+	}
+	if len(wsMsg.ClientMsgID) > database.MaxClientMsgIDLength {
+		c.sendNack(wsMsg, "client_msg_id is too long")
+		return
+	}
+
+	var saved *database.Message
+	var err error
+
+	switch {
+	case wsMsg.RoomID > 0 && wsMsg.ToUserID == 0:
+		if err := c.checkRoomPost(wsMsg.RoomID); err != nil {
+			log.Printf("🚫 Client %d (%s) message to room %d rejected: %v", c.userID, c.username, wsMsg.RoomID, err)
+			c.sendNack(wsMsg, "Cannot send to room: "+err.Error())
+			return
+		}
+		log.Printf("💾 Saving room message to DB: from=%d, room=%d", c.userID, wsMsg.RoomID)
+		saved, err = c.saveRoomMessage(wsMsg.RoomID, wsMsg.Text, wsMsg.ClientMsgID, wsMsg.ReplyToID, wsMsg.ThreadRootID)
+
+	case wsMsg.ToUserID > 0:
+		if saveMessageToDB == nil {
+			err = errors.New("saveMessageToDB is nil")
+			break
+		}
+		log.Printf("💾 Saving private message to DB: from=%d, to=%d", c.userID, wsMsg.ToUserID)
+		saved, err = saveMessageToDB(c.userID, wsMsg.ToUserID, wsMsg.Text, wsMsg.ClientMsgID, wsMsg.ReplyToID, wsMsg.ThreadRootID)
+
+	default:
+		// Chat messages must target a user or a room, never everyone
+		c.sendNack(wsMsg, "Message must have a to_user_id or room_id")
+		return
+	}
+
+	if err != nil {
+		log.Printf("❌ Error saving message from user %d: %v", c.userID, err)
+		if errors.Is(err, database.ErrNotFriends) {
+			c.sendNack(wsMsg, "You can only send direct messages to friends")
+			return
+		}
+		if errors.Is(err, database.ErrInvalidReply) {
+			c.sendNack(wsMsg, err.Error())
+			return
+		}
+		c.sendNack(wsMsg, "Failed to save message")
+		return
+	}
+
+	// A retry was already delivered the first time, only the ack was lost
+	if !saved.Duplicate {
+		frame := messageFrame(saved, c.username)
+		frame.TempID = wsMsg.TempID
+		c.hub.deliverChatFrame(frame)
+	}
+
+	ack := deliveryAck{
+		Type:        "ack",
+		TempID:      wsMsg.TempID,
+		ClientMsgID: saved.ClientMsgID,
+		MessageID:   saved.ID,
+		CreatedAt:   &saved.CreatedAt,
+		Duplicate:   saved.Duplicate,
+	}
+	if saved.ToUserID != nil {
+		ack.ToUserID = *saved.ToUserID
+	}
+	if saved.RoomID != nil {
+		ack.RoomID = *saved.RoomID
+	}
+	c.sendFrame(ack)
+}
+
+// sendNack rejects a chat message back to the sending connection
+func (c *Client) sendNack(wsMsg WSMessage, reason string) {
+	c.sendFrame(deliveryAck{
+		Type:        "nack",
+		TempID:      wsMsg.TempID,
+		ClientMsgID: wsMsg.ClientMsgID,
+		ToUserID:    wsMsg.ToUserID,
+		RoomID:      wsMsg.RoomID,
+		Error:       reason,
+	})
+}
+
+// messageFrame builds the "message" frame delivered for a persisted message
+func messageFrame(msg *database.Message, fromUsername string) WSMessage {
+	frame := WSMessage{
+		Type:        "message",
+		From:        fromUsername,
+		FromUserID:  msg.FromUserID,
+		Text:        msg.Text,
+		MessageID:   msg.ID,
+		ClientMsgID: msg.ClientMsgID,
+		CreatedAt:   &msg.CreatedAt,
+	}
+	if msg.ToUserID != nil {
+		frame.ToUserID = *msg.ToUserID
+	}
+	if msg.RoomID != nil {
+		frame.RoomID = *msg.RoomID
+	}
+	if msg.ReplyToID != nil {
+		frame.ReplyToID = *msg.ReplyToID
+	}
+	if msg.ThreadRootID != nil {
+		frame.ThreadRootID = *msg.ThreadRootID
+	}
+	return frame
+}
+
+// deliverChatFrame routes a persisted chat message to the room, or to the
+// recipient and the sender's own connections for direct messages
+func (h *Hub) deliverChatFrame(frame WSMessage) {
+	message, err := json.Marshal(frame)
+	if err != nil {
+		log.Printf("❌ Failed to marshal message %d: %v", frame.MessageID, err)
+		return
+	}
+
+	if frame.RoomID > 0 && frame.ToUserID == 0 {
+		log.Printf("🏠 ROOM MESSAGE %d: from user %d to room %d", frame.MessageID, frame.FromUserID, frame.RoomID)
+		h.SendRoomMessage(message, frame.RoomID)
+		return
+	}
+
+	log.Printf("📤 DIRECT MESSAGE %d: from user %d to user %d", frame.MessageID, frame.FromUserID, frame.ToUserID)
+	h.SendDirectMessage(message, frame.ToUserID)
+	// The sender's connections show the persisted copy
+	h.SendDirectMessage(message, frame.FromUserID)
+	h.markDelivered(frame.MessageID, frame.ToUserID)
+}
+
+// markDelivered marks a direct message delivered when the recipient is online
+// on any instance. Otherwise it stays queued and is flushed when they connect.
+// Without presence tracking every message counts as delivered.
+func (h *Hub) markDelivered(messageID, toUserID int) {
+	if h.db == nil || messageID == 0 {
+		return
+	}
+	if h.cacheService != nil {
+		online, err := h.cacheService.IsUserOnline(toUserID)
+		if err != nil || !online {
+			log.Printf("📭 User %d is offline, message %d queued for delivery", toUserID, messageID)
+			return
+		}
+	}
+	if err := h.db.MarkMessageDelivered(messageID); err != nil {
+		log.Printf("⚠️ Failed to mark message %d delivered: %v", messageID, err)
+	}
+}
+
+// DeliverMessage delivers a message persisted outside the WebSocket (REST send)
+func (h *Hub) DeliverMessage(msg *database.Message, fromUsername string) {
+	h.deliverChatFrame(messageFrame(msg, fromUsername))
+}
@@ -2,37 +2,69 @@ package websocket
 
 import (
 	"e5realtimechat/internal/cache"
+	"e5realtimechat/internal/database"
 	"encoding/json"
+	"fmt"
 	"log"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
 
 	ws "github.com/gorilla/websocket"
 )
 
 // Message structure for routing
 type WSMessage struct {
-	Type       string `json:"type"`         // "message", "join", "leave", "user_status", "heartbeat"
-	From       string `json:"from"`         // username of sender
-	FromUserID int    `json:"from_user_id"` // user ID of sender
-	ToUserID   int    `json:"to_user_id"`   // user ID of recipient (0 = broadcast)
-	Text       string `json:"text"`
-	User       string `json:"user"`
-	UserID     int    `json:"user_id,omitempty"`   // for status updates
-	IsOnline   bool   `json:"is_online,omitempty"` // for status updates
-	Username   string `json:"username,omitempty"`  // for status updates
+	Type         string     `json:"type"`                     // "message", "join", "leave", "join_room", "leave_room", "read", "typing_start", "typing_stop", "edit_message", "delete_message", "react", "unreact", "user_status", "session_revoked", "heartbeat"
+	From         string     `json:"from"`                     // username of sender
+	FromUserID   int        `json:"from_user_id"`             // user ID of sender
+	ToUserID     int        `json:"to_user_id"`               // user ID of recipient (0 = not a direct message)
+	RoomID       int        `json:"room_id,omitempty"`        // room ID for room messages and subscriptions
+	MessageID    int        `json:"message_id,omitempty"`     // message ID referenced by the frame (read receipts)
+	TempID       string     `json:"temp_id,omitempty"`        // client-side ID echoed in ack/nack frames
+	ClientMsgID  string     `json:"client_msg_id,omitempty"`  // client-generated ID, retries with the same ID are not re-sent
+	CreatedAt    *time.Time `json:"created_at,omitempty"`     // set by the server once the message is persisted
+	ReplyToID    int        `json:"reply_to_id,omitempty"`    // quoted message, must be in the same conversation
+	ThreadRootID int        `json:"thread_root_id,omitempty"` // post the message in this message's thread
+	Scope        string     `json:"scope,omitempty"`          // delete_message: "me" or "everyone" (default)
+	Emoji        string     `json:"emoji,omitempty"`          // react / unreact
+	Text         string     `json:"text"`
+	User         string     `json:"user"`
+	UserID       int        `json:"user_id,omitempty"`           // for status updates
+	IsOnline     bool       `json:"is_online,omitempty"`         // for status updates
+	Presence     string     `json:"presence,omitempty"`          // for status updates: "visible" or "dnd" while online
+	StatusText   string     `json:"status_text,omitempty"`       // for status updates: custom status
+	StatusEmoji  string     `json:"status_emoji,omitempty"`      // for status updates: custom status
+	StatusExpiry *time.Time `json:"status_expires_at,omitempty"` // for status updates: custom status
+	Username     string     `json:"username,omitempty"`          // for status updates
 }
 
 // // Hub quản lý tất cả client đang kết nối và phân phối tin nhắn giữa họ
 type Hub struct {
 	clients      map[*Client]bool
+	rooms        map[int]map[*Client]bool // room ID → local clients subscribed to it
 	broadcast    chan []byte
-	directMsg    chan *DirectMessage // channel for direct messages
+	directMsg    chan *DirectMessage    // channel for direct messages
+	roomMsg      chan *RoomMessage      // channel for room messages
+	roomJoin     chan *roomSubscription // client subscribes to a room
+	roomLeave    chan *roomSubscription // client unsubscribes from a room
+	control      chan *controlCommand   // cross-instance control commands, fed by controlLoop
+	controlIn    chan *controlCommand   // control commands waiting for controlLoop
+	replayDone   chan *replayResult     // missed frames loaded for a (re)connecting client
+	presence     chan presenceChange    // online/offline transitions announced by presenceLoop
 	register     chan *Client
 	unregister   chan *Client
+	db           *database.DB        // database for room membership and persistence
 	cacheService *cache.CacheService // Redis cache for online status
 	rateLimiter  interface {         // Rate limiter for message throttling
 		CheckUserMessageRate(userID int) (bool, error)
+		CheckUserTypingRate(userID int) (bool, error)
+		CheckUserReadRate(userID int) (bool, error)
 	}
 	redisClient *cache.RedisClient // Redis client for Pub/Sub cross-instance messaging
+	instanceID  string             // identifies this server instance in Pub/Sub envelopes
+	connSeq     atomic.Int64       // last connection ID handed out by Register
 }
 
 // DirectMessage contains message and target user ID
@@ -43,16 +75,44 @@ type DirectMessage struct {
 
 // NewHub khởi tạo 1 hub mới
 func NewHub() *Hub {
-	return &Hub{
+	h := &Hub{
 		clients:      make(map[*Client]bool),
-		broadcast:    make(chan []byte),
-		directMsg:    make(chan *DirectMessage),
+		rooms:        make(map[int]map[*Client]bool),
+		broadcast:    make(chan []byte, 256),
+		directMsg:    make(chan *DirectMessage, 256),
+		roomMsg:      make(chan *RoomMessage, 256),
+		roomJoin:     make(chan *roomSubscription),
+		roomLeave:    make(chan *roomSubscription),
+		control:      make(chan *controlCommand, 64),
+		controlIn:    make(chan *controlCommand),
+		replayDone:   make(chan *replayResult, 64),
+		presence:     make(chan presenceChange, presenceQueueSize),
 		register:     make(chan *Client),
 		unregister:   make(chan *Client),
 		cacheService: nil,
 		rateLimiter:  nil,
 		redisClient:  nil,
+		instanceID:   newInstanceID(),
 	}
+	// Control commands are queued from the start: the Redis subscriber may
+	// receive some before Run, and must never wait for the Hub
+	go h.controlLoop()
+	return h
+}
+
+// newInstanceID returns the container hostname, falling back to hostname+pid
+// so that two local processes never share an ID
+func newInstanceID() string {
+	if id := os.Getenv("HOSTNAME"); id != "" {
+		return id
+	}
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// InstanceID returns the identifier of this server instance
+func (h *Hub) InstanceID() string {
+	return h.instanceID
 }
 
 // SetCacheService sets the cache service for the hub
@@ -60,9 +120,16 @@ func (h *Hub) SetCacheService(cacheService *cache.CacheService) {
 	h.cacheService = cacheService
 }
 
+// SetDatabase sets the database used for room membership and room messages
+func (h *Hub) SetDatabase(db *database.DB) {
+	h.db = db
+}
+
 // SetRateLimiter sets the rate limiter for the hub
 func (h *Hub) SetRateLimiter(rateLimiter interface {
 	CheckUserMessageRate(userID int) (bool, error)
+	CheckUserTypingRate(userID int) (bool, error)
+	CheckUserReadRate(userID int) (bool, error)
 }) {
 	h.rateLimiter = rateLimiter
 }
@@ -79,21 +146,39 @@ func (h *Hub) SetRedisClient(redisClient *cache.RedisClient) {
 
 // // Run chạy liên tục, xử lý các sự kiện từ các channel
 func (h *Hub) Run() {
+	if h.cacheService != nil {
+		h.cleanupPreviousRun()
+	}
+	go h.presenceLoop()
+	reconcile := time.NewTicker(presenceReconcileInterval)
+	defer reconcile.Stop()
+
 	for {
 		select {
 		case client := <-h.register:
 			//thêm client mới vào danh sách
 			h.clients[client] = true
+			h.startSession(client)
 
 			// Mark user as online in cache
 			if h.cacheService != nil && client.userID > 0 {
+				// Only the user's first connection, on any instance, changes their status
+				first := true
+				if count, err := h.cacheService.AddUserConnection(client.userID, client.deviceConnection(h.instanceID)); err != nil {
+					log.Printf("⚠️ Failed to record connection of user %d: %v", client.userID, err)
+				} else {
+					first = count == 1
+				}
+
 				if err := h.cacheService.SetUserOnline(client.userID); err != nil {
 					log.Printf("⚠️ Failed to set user %d online: %v", client.userID, err)
-				} else {
+				} else if first {
 					log.Printf("✅ User %d (%s) is now ONLINE", client.userID, client.username)
 
-					// Broadcast user online status to all clients
-					h.broadcastUserStatus(client.userID, client.username, true)
+					// Notify friends and shared-room members
+					h.queuePresenceChange(presenceChange{userID: client.userID, username: client.username, online: true})
+				} else {
+					log.Printf("📱 User %d (%s) opened another connection (%s)", client.userID, client.username, client.device)
 				}
 			}
 
@@ -101,18 +186,33 @@ func (h *Hub) Run() {
 			//xóa client khi ngắt kết nối
 			if _, ok := h.clients[client]; ok {
 				delete(h.clients, client)
-				close(client.send)
+				h.removeFromAllRooms(client)
+			}
+			// readPump has exited, nothing else writes to send anymore
+			close(client.send)
 
-				// Mark user as offline in cache
-				if h.cacheService != nil && client.userID > 0 {
-					if err := h.cacheService.SetUserOffline(client.userID); err != nil {
-						log.Printf("⚠️ Failed to set user %d offline: %v", client.userID, err)
-					} else {
-						log.Printf("👋 User %d (%s) is now OFFLINE", client.userID, client.username)
+			// Dropped clients (full send buffer, revoked session) were already
+			// removed above, their connection is released here as well
+			if h.cacheService != nil && client.userID > 0 {
+				// From now on the reconciler leaves the connection alone
+				client.mu.Lock()
+				client.released = true
+				client.mu.Unlock()
+
+				remaining, err := h.cacheService.RemoveUserConnection(client.userID, h.instanceID, client.connID)
+				if err != nil {
+					log.Printf("⚠️ Failed to release connection of user %d: %v", client.userID, err)
+				}
+				if err == nil && remaining > 0 {
+					// Another tab or device is still connected
+					log.Printf("📱 User %d (%s) closed a connection, %d left", client.userID, client.username, remaining)
+				} else if err := h.cacheService.SetUserOffline(client.userID); err != nil {
+					log.Printf("⚠️ Failed to set user %d offline: %v", client.userID, err)
+				} else {
+					log.Printf("👋 User %d (%s) is now OFFLINE", client.userID, client.username)
 
-						// Broadcast user offline status to all clients
-						h.broadcastUserStatus(client.userID, client.username, false)
-					}
+					// Notify friends and shared-room members
+					h.queuePresenceChange(presenceChange{userID: client.userID, username: client.username, online: false})
 				}
 			}
 
@@ -128,46 +228,96 @@ func (h *Hub) Run() {
 				default:
 					// Nếu không gửi được → đóng kết nối client
 					log.Printf("❌ Failed to send to client %d (%s), closing connection", client.userID, client.username)
-					close(client.send)
-					delete(h.clients, client)
+					h.dropClient(client)
 				}
 			}
 			log.Printf("📢 Broadcast complete: sent to %d/%d clients", sentCount, len(h.clients))
 
+		case sub := <-h.roomJoin:
+			if _, ok := h.clients[sub.client]; !ok {
+				continue // client already disconnected
+			}
+			if h.rooms[sub.roomID] == nil {
+				h.rooms[sub.roomID] = make(map[*Client]bool)
+			}
+			h.rooms[sub.roomID][sub.client] = true
+			log.Printf("🚪 Client %d (%s) joined room %d (%d local members)", sub.client.userID, sub.client.username, sub.roomID, len(h.rooms[sub.roomID]))
+
+		case sub := <-h.roomLeave:
+			h.removeFromRoom(sub.client, sub.roomID)
+			log.Printf("🚪 Client %d (%s) left room %d", sub.client.userID, sub.client.username, sub.roomID)
+
+		case cmd := <-h.control:
+			h.handleControl(cmd)
+
+		case <-reconcile.C:
+			if h.cacheService != nil {
+				go h.reconcilePresence(h.localConnections())
+			}
+			go h.clearExpiredStatuses()
+
+		case result := <-h.replayDone:
+			h.finishSession(result)
+
+		case roomMsg := <-h.roomMsg:
+			// Gửi tin nhắn tới các client đã tham gia room trên instance này
+			for client := range h.rooms[roomMsg.roomID] {
+				select {
+				case client.send <- roomMsg.message:
+				default:
+					log.Printf("❌ Failed to send room %d message to client %d (%s), closing connection", roomMsg.roomID, client.userID, client.username)
+					h.dropClient(client)
+				}
+			}
+
 		case directMsg := <-h.directMsg:
 			// Gửi tin nhắn riêng tư cho user cụ thể
 			log.Printf("🎯 Hub received directMsg for user %d: %s", directMsg.toUserID, string(directMsg.message))
 			log.Printf("🔍 Searching for recipient in %d connected clients...", len(h.clients))
+			// A user may have several tabs open, deliver to every connection
 			found := false
 			for client := range h.clients {
 				if client.userID == directMsg.toUserID {
 					found = true
 					log.Printf("✅ Found recipient: client %d (%s)", client.userID, client.username)
+					if client.syncing {
+						// Delivered after the replay so frames stay in order
+						client.pending = append(client.pending, directMsg.message)
+						continue
+					}
 					select {
 					case client.send <- directMsg.message:
 						log.Printf("✅ Message sent to client %d (%s) successfully", client.userID, client.username)
 					default:
 						log.Printf("❌ Failed to send to client %d (%s), channel blocked. Closing connection.", client.userID, client.username)
-						close(client.send)
-						delete(h.clients, client)
+						h.dropClient(client)
 					}
-					break
 				}
 			}
 			if !found {
-				log.Printf("⚠️ Recipient user %d not found in connected clients", directMsg.toUserID)
+				// Not an error: the recipient may be connected to another instance
+				log.Printf("ℹ️ Recipient user %d not connected to this instance", directMsg.toUserID)
 			}
 		}
 	}
 }
 
-// SendDirectMessage sends a message to a specific user
+// SendDirectMessage sends a message to a specific user, wherever they are
+// connected. The frame is sequenced and replayed on resume, short-lived
+// frames go through multicast instead.
 func (h *Hub) SendDirectMessage(message []byte, toUserID int) {
 	log.Printf("🎯 Hub.SendDirectMessage called: toUserID=%d, message=%s", toUserID, string(message))
-	h.directMsg <- &DirectMessage{
-		message:  message,
-		toUserID: toUserID,
+	message = h.sequenceFrame(message, toUserID)
+	if err := h.DirectViaRedis(message, toUserID); err != nil {
+		log.Printf("⚠️ Failed to route direct message via Redis: %v", err)
 	}
+}
+
+// deliverDirectLocal queues a direct message for clients connected to this
+// instance. It waits for room in the queue rather than lose the frame, so it
+// must not be called from the Hub goroutine.
+func (h *Hub) deliverDirectLocal(message []byte, toUserID int) {
+	h.directMsg <- &DirectMessage{message: message, toUserID: toUserID}
 	log.Printf("✅ Message queued in directMsg channel for user %d", toUserID)
 }
 
@@ -176,19 +326,41 @@ func (h *Hub) SendBroadcast(message []byte) {
 	h.broadcast <- message
 }
 
-// Register registers a new client
-func (h *Hub) Register(conn interface{}, userID int, username string) *Client {
+// Register registers a new client. lastSeq is the last frame seq the client
+// received in a previous session, or -1 for a fresh session. device describes
+// the connecting browser or app, as listed by the devices endpoint. sessionID
+// is the login session of the access token, used to disconnect it when revoked.
+func (h *Hub) Register(conn interface{}, userID int, username string, lastSeq int64, device string, sessionID int) *Client {
 	client := &Client{
-		hub:      h,
-		conn:     conn.(*ws.Conn),
-		send:     make(chan []byte, 256),
-		userID:   userID,
-		username: username,
+		hub:         h,
+		conn:        conn.(*ws.Conn),
+		send:        make(chan []byte, 256),
+		done:        make(chan struct{}),
+		userID:      userID,
+		username:    username,
+		connID:      strconv.FormatInt(h.connSeq.Add(1), 10),
+		sessionID:   sessionID,
+		device:      device,
+		connectedAt: time.Now(),
+		rooms:       make(map[int]bool),
+		typing:      make(map[typingTarget]time.Time),
+		blocks:      make(map[int]blockCheck),
+		resumeFrom:  lastSeq,
 	}
 	h.register <- client
 	return client
 }
 
+// deviceConnection describes the client for the user's device list
+func (c *Client) deviceConnection(instanceID string) *cache.DeviceConnection {
+	return &cache.DeviceConnection{
+		ConnID:      c.connID,
+		Instance:    instanceID,
+		Device:      c.device,
+		ConnectedAt: c.connectedAt,
+	}
+}
+
 // StartClient starts the read and write pumps for a client
 func (c *Client) StartClient() {
 	go c.writePump()
@@ -199,6 +371,21 @@ func (c *Client) StartClient() {
 
 const redisBroadcastChannel = "chat:broadcast"
 const redisDirectMsgChannel = "chat:direct"
+const redisRoomMsgChannel = "chat:room"
+const redisControlChannel = "chat:control"
+
+// redisEnvelope is the payload published on the direct, room and control channels.
+// Origin lets the publishing instance skip its own echo, since it has
+// already delivered to its local clients.
+type redisEnvelope struct {
+	Origin   string          `json:"origin"`
+	ToUserID int             `json:"to_user_id,omitempty"`
+	RoomID   int             `json:"room_id,omitempty"`
+	Action   string          `json:"action,omitempty"` // control channel only
+	Payload  json.RawMessage `json:"payload,omitempty"`
+	UserIDs  []int           `json:"user_ids,omitempty"` // control multicast only
+	Session  int             `json:"session,omitempty"`  // control disconnect only
+}
 
 // BroadcastViaRedis publishes a broadcast message to Redis
 // This allows messages to be received by clients connected to other server instances
@@ -241,39 +428,181 @@ func (h *Hub) BroadcastViaRedis(message []byte) error {
 	return nil
 }
 
+// DirectViaRedis publishes a per-user message to Redis so that whichever
+// instance holds the recipient's connection can deliver it
+func (h *Hub) DirectViaRedis(message []byte, toUserID int) error {
+	if h.redisClient == nil {
+		// Fallback to local delivery only
+		h.deliverDirectLocal(message, toUserID)
+		return nil
+	}
+
+	envelope, err := json.Marshal(redisEnvelope{
+		Origin:   h.instanceID,
+		ToUserID: toUserID,
+		Payload:  message,
+	})
+	if err != nil {
+		// Payload is not valid JSON, it can only be delivered locally
+		h.deliverDirectLocal(message, toUserID)
+		return fmt.Errorf("failed to marshal direct envelope: %w", err)
+	}
+
+	if err := h.redisClient.Publish(redisDirectMsgChannel, string(envelope)); err != nil {
+		log.Printf("⚠️ Failed to publish direct message to Redis: %v", err)
+		// Fallback to local delivery
+		h.deliverDirectLocal(message, toUserID)
+		return err
+	}
+	log.Printf("📤 Direct message for user %d published to Redis channel %s", toUserID, redisDirectMsgChannel)
+
+	// Also deliver to local clients immediately (our own echo is skipped)
+	h.deliverDirectLocal(message, toUserID)
+	return nil
+}
+
 // subscribeToRedis listens for messages published by other server instances
 func (h *Hub) subscribeToRedis() {
-	pubsub := h.redisClient.Subscribe(redisBroadcastChannel)
+	pubsub := h.redisClient.Subscribe(redisBroadcastChannel, redisDirectMsgChannel, redisRoomMsgChannel, redisControlChannel)
 	defer pubsub.Close()
 
 	ch := pubsub.Channel()
 
-	log.Printf("📡 Listening on Redis channel: %s", redisBroadcastChannel)
+	log.Printf("📡 Listening on Redis channels: %s, %s, %s, %s", redisBroadcastChannel, redisDirectMsgChannel, redisRoomMsgChannel, redisControlChannel)
 
 	for msg := range ch {
-		// Received message from another instance, broadcast to local clients
-		message := []byte(msg.Payload)
+		switch msg.Channel {
+		case redisDirectMsgChannel, redisRoomMsgChannel, redisControlChannel:
+			var envelope redisEnvelope
+			if err := json.Unmarshal([]byte(msg.Payload), &envelope); err != nil {
+				log.Printf("⚠️ Invalid envelope on %s from Redis: %v", msg.Channel, err)
+				continue
+			}
+			if envelope.Origin == h.instanceID {
+				continue // already delivered locally by the publisher
+			}
+			switch msg.Channel {
+			case redisRoomMsgChannel:
+				h.deliverRoomLocal(envelope.Payload, envelope.RoomID)
+			case redisControlChannel:
+				h.applyControlLocal(&controlCommand{
+					action:  envelope.Action,
+					userID:  envelope.ToUserID,
+					roomID:  envelope.RoomID,
+					payload: envelope.Payload,
+					userIDs: envelope.UserIDs,
+					session: envelope.Session,
+				})
+			default:
+				h.deliverDirectLocal(envelope.Payload, envelope.ToUserID)
+			}
 
-		// Send to local clients only (don't re-publish to avoid loops)
-		for client := range h.clients {
+		default:
+			// Received message from another instance, broadcast to local clients.
+			// Hand it to Run so h.clients is only touched from one goroutine
+			// (don't re-publish to avoid loops)
 			select {
-			case client.send <- message:
+			case h.broadcast <- []byte(msg.Payload):
 			default:
-				// Client channel full, skip this message for this client
-				log.Printf("⚠️ Client %d channel full, skipping message", client.userID)
+				log.Printf("⚠️ Broadcast channel full, skipping message from Redis")
 			}
 		}
 	}
 }
 
-// broadcastUserStatus broadcasts user online/offline status to all connected clients
+// broadcastUserStatus sends a user's online/offline change to the users allowed
+// to see their presence. Nothing is sent for invisible users, who always appear offline.
 func (h *Hub) broadcastUserStatus(userID int, username string, isOnline bool) {
+	mode := database.PresenceVisible
+	if h.db != nil {
+		var err error
+		if mode, err = h.db.GetPresenceMode(userID); err != nil {
+			log.Printf("⚠️ Failed to get presence mode of user %d: %v", userID, err)
+			mode = database.PresenceVisible
+		}
+	}
+	if mode == database.PresenceInvisible {
+		log.Printf("🙈 User %d (%s) is invisible, status not broadcasted", userID, username)
+		return
+	}
+	h.sendUserStatus(userID, username, isOnline, mode)
+}
+
+// UpdatePresenceMode notifies the audience of a user after they changed their
+// presence mode. Offline users have nothing to announce.
+func (h *Hub) UpdatePresenceMode(userID int, username, mode string) {
+	if h.cacheService == nil {
+		return
+	}
+	online, err := h.cacheService.IsUserOnline(userID)
+	if err != nil {
+		log.Printf("⚠️ Failed to check if user %d is online: %v", userID, err)
+		return
+	}
+	if !online {
+		return
+	}
+	// Going invisible looks like going offline
+	h.sendUserStatus(userID, username, mode != database.PresenceInvisible, mode)
+}
+
+// NotifyUserStatus sends the current status of a user to their audience after
+// their custom status changed. Invisible users appear offline but their
+// custom status is still shown.
+func (h *Hub) NotifyUserStatus(userID int, username string) {
+	mode := database.PresenceVisible
+	if h.db != nil {
+		var err error
+		if mode, err = h.db.GetPresenceMode(userID); err != nil {
+			log.Printf("⚠️ Failed to get presence mode of user %d: %v", userID, err)
+			mode = database.PresenceVisible
+		}
+	}
+
+	online := false
+	if h.cacheService != nil && mode != database.PresenceInvisible {
+		var err error
+		if online, err = h.cacheService.IsUserOnline(userID); err != nil {
+			log.Printf("⚠️ Failed to check if user %d is online: %v", userID, err)
+		}
+	}
+	h.sendUserStatus(userID, username, online, mode)
+}
+
+// SendOfflineStatus shows a user as offline to some users whatever their
+// actual status, e.g. to a user they just blocked. Like every presence
+// frame it is not sequenced, so it is never replayed.
+func (h *Hub) SendOfflineStatus(userID int, username string, toUserIDs ...int) {
+	statusBytes, err := json.Marshal(WSMessage{
+		Type:     "user_status",
+		UserID:   userID,
+		Username: username,
+		IsOnline: false,
+	})
+	if err != nil {
+		log.Printf("⚠️ Failed to marshal status message: %v", err)
+		return
+	}
+	h.multicast(statusBytes, toUserIDs)
+}
+
+// sendUserStatus sends a user_status frame to the friends and shared-room
+// members of a user, on all instances
+func (h *Hub) sendUserStatus(userID int, username string, isOnline bool, mode string) {
 	statusMsg := WSMessage{
 		Type:     "user_status",
 		UserID:   userID,
 		Username: username,
 		IsOnline: isOnline,
 	}
+	if isOnline {
+		statusMsg.Presence = mode
+	}
+	if profile := h.userProfile(userID); profile != nil {
+		statusMsg.StatusText = profile.StatusText
+		statusMsg.StatusEmoji = profile.StatusEmoji
+		statusMsg.StatusExpiry = profile.StatusExpiresAt
+	}
 
 	msgBytes, err := json.Marshal(statusMsg)
 	if err != nil {
@@ -281,10 +610,19 @@ func (h *Hub) broadcastUserStatus(userID int, username string, isOnline bool) {
 		return
 	}
 
-	// Broadcast via Redis for cross-instance delivery
-	if err := h.BroadcastViaRedis(msgBytes); err != nil {
-		log.Printf("⚠️ Failed to broadcast status via Redis: %v", err)
+	if h.db == nil {
+		// No way to know who may see the status, keep it to ourselves
+		return
+	}
+	audience, err := h.db.GetPresenceAudience(userID)
+	if err != nil {
+		log.Printf("⚠️ Failed to get presence audience of user %d: %v", userID, err)
+		return
+	}
+	if len(audience) == 0 {
+		return
 	}
 
-	log.Printf("📢 Broadcasted status: user=%d (%s) online=%v", userID, username, isOnline)
+	h.multicast(msgBytes, audience)
+	log.Printf("📢 Sent status: user=%d (%s) online=%v presence=%s to %d user(s)", userID, username, isOnline, statusMsg.Presence, len(audience))
 }
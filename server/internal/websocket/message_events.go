@@ -0,0 +1,209 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"e5realtimechat/internal/database"
+)
+
+// messageEvent tells conversation participants that an existing message changed
+type messageEvent struct {
+	Type       string     `json:"type"` // "message_edited", "message_deleted", "message_hidden", "reaction_added", "reaction_removed"
+	MessageID  int        `json:"message_id"`
+	FromUserID int        `json:"from_user_id"`
+	ToUserID   int        `json:"to_user_id,omitempty"`
+	RoomID     int        `json:"room_id,omitempty"`
+	Text       string     `json:"text,omitempty"`
+	EditedAt   *time.Time `json:"edited_at,omitempty"`
+	DeletedAt  *time.Time `json:"deleted_at,omitempty"`
+}
+
+// newMessageEvent builds an event addressed to the message's conversation
+func newMessageEvent(eventType string, msg *database.Message) messageEvent {
+	event := messageEvent{
+		Type:       eventType,
+		MessageID:  msg.ID,
+		FromUserID: msg.FromUserID,
+	}
+	if msg.ToUserID != nil {
+		event.ToUserID = *msg.ToUserID
+	}
+	if msg.RoomID != nil {
+		event.RoomID = *msg.RoomID
+	}
+	return event
+}
+
+// SendToConversation delivers a frame to everyone in a message's conversation:
+// the room members, or both users of a direct conversation
+func (h *Hub) SendToConversation(msg *database.Message, frame []byte) {
+	if msg.RoomID != nil {
+		h.SendRoomMessage(frame, *msg.RoomID)
+		return
+	}
+	if msg.ToUserID != nil {
+		h.SendDirectMessage(frame, *msg.ToUserID)
+		if *msg.ToUserID == msg.FromUserID {
+			return
+		}
+	}
+	h.SendDirectMessage(frame, msg.FromUserID)
+}
+
+// multicastToConversation is SendToConversation for short-lived events:
+// direct conversations get the frame unsequenced, so it is never replayed
+func (h *Hub) multicastToConversation(msg *database.Message, frame []byte) {
+	if msg.RoomID != nil {
+		h.SendRoomMessage(frame, *msg.RoomID)
+		return
+	}
+	userIDs := []int{msg.FromUserID}
+	if msg.ToUserID != nil && *msg.ToUserID != msg.FromUserID {
+		userIDs = append(userIDs, *msg.ToUserID)
+	}
+	h.multicast(frame, userIDs)
+}
+
+// sendMessageEvent encodes an event and sends it to the message's conversation
+func (h *Hub) sendMessageEvent(msg *database.Message, event messageEvent) {
+	eventBytes, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("⚠️ Failed to marshal %s event: %v", event.Type, err)
+		return
+	}
+	h.SendToConversation(msg, eventBytes)
+}
+
+// NotifyMessageEdited pushes a message_edited event to the conversation
+func (h *Hub) NotifyMessageEdited(msg *database.Message) {
+	event := newMessageEvent("message_edited", msg)
+	event.Text = msg.Text
+	event.EditedAt = msg.EditedAt
+	h.sendMessageEvent(msg, event)
+}
+
+// NotifyMessageDeleted pushes a message_deleted event to the conversation
+func (h *Hub) NotifyMessageDeleted(msg *database.Message) {
+	event := newMessageEvent("message_deleted", msg)
+	event.Text = msg.Text
+	event.DeletedAt = msg.DeletedAt
+	h.sendMessageEvent(msg, event)
+}
+
+// NotifyMessageHidden tells the other connections of a user that they
+// deleted a message for themselves
+func (h *Hub) NotifyMessageHidden(msg *database.Message, userID int) {
+	eventBytes, err := json.Marshal(newMessageEvent("message_hidden", msg))
+	if err != nil {
+		log.Printf("⚠️ Failed to marshal message_hidden event: %v", err)
+		return
+	}
+	h.SendDirectMessage(eventBytes, userID)
+}
+
+// handleEditMessage processes edit_message frames
+func (c *Client) handleEditMessage(wsMsg WSMessage) {
+	if wsMsg.MessageID <= 0 || wsMsg.Text == "" {
+		c.sendError("edit_message requires message_id and text")
+		return
+	}
+	if c.hub.db == nil {
+		c.sendError("Editing is not available")
+		return
+	}
+	// Unknown messages are reported by EditMessage below
+	if msg, err := c.hub.db.GetMessageByID(wsMsg.MessageID); err == nil && c.blockedWith(c.messagePeer(msg)) {
+		c.sendError("You cannot message this user")
+		return
+	}
+
+	msg, err := c.hub.db.EditMessage(wsMsg.MessageID, c.userID, wsMsg.Text)
+	if err != nil {
+		log.Printf("⚠️ User %d failed to edit message %d: %v", c.userID, wsMsg.MessageID, err)
+		switch {
+		case errors.Is(err, database.ErrMessageNotFound),
+			errors.Is(err, database.ErrNotMessageAuthor),
+			errors.Is(err, database.ErrEditWindowExpired),
+			errors.Is(err, database.ErrMessageUnchanged),
+			errors.Is(err, database.ErrMessageDeleted),
+			errors.Is(err, database.ErrNotRoomMember),
+			errors.Is(err, database.ErrRoomBanned),
+			errors.Is(err, database.ErrRoomMuted):
+			c.sendError("Cannot edit message: " + err.Error())
+		default:
+			c.sendError("Failed to edit message")
+		}
+		return
+	}
+
+	log.Printf("✏️ User %d edited message %d", c.userID, msg.ID)
+	c.hub.NotifyMessageEdited(msg)
+}
+
+// handleDeleteMessage processes delete_message frames
+func (c *Client) handleDeleteMessage(wsMsg WSMessage) {
+	if wsMsg.MessageID <= 0 {
+		c.sendError("delete_message requires message_id")
+		return
+	}
+	if c.hub.db == nil {
+		c.sendError("Deleting is not available")
+		return
+	}
+
+	switch wsMsg.Scope {
+	case "me":
+		msg, err := c.hub.db.GetMessageByID(wsMsg.MessageID)
+		if err == nil && !c.canSeeMessage(msg) {
+			err = database.ErrMessageNotFound
+		}
+		if err == nil {
+			err = c.hub.db.HideMessage(msg.ID, c.userID)
+		}
+		if err != nil {
+			log.Printf("⚠️ User %d failed to hide message %d: %v", c.userID, wsMsg.MessageID, err)
+			if errors.Is(err, database.ErrMessageNotFound) {
+				c.sendError("Cannot delete message: " + err.Error())
+				return
+			}
+			c.sendError("Failed to delete message")
+			return
+		}
+		log.Printf("🙈 User %d hid message %d", c.userID, msg.ID)
+		c.hub.NotifyMessageHidden(msg, c.userID)
+
+	case "", "everyone":
+		msg, err := c.hub.db.DeleteMessage(wsMsg.MessageID, c.userID)
+		if err != nil {
+			log.Printf("⚠️ User %d failed to delete message %d: %v", c.userID, wsMsg.MessageID, err)
+			switch {
+			case errors.Is(err, database.ErrMessageNotFound),
+				errors.Is(err, database.ErrCannotDeleteMessage),
+				errors.Is(err, database.ErrMessageDeleted):
+				c.sendError("Cannot delete message: " + err.Error())
+			default:
+				c.sendError("Failed to delete message")
+			}
+			return
+		}
+		log.Printf("🗑️ User %d deleted message %d for everyone", c.userID, msg.ID)
+		c.hub.NotifyMessageDeleted(msg)
+
+	default:
+		c.sendError("delete_message scope must be \"me\" or \"everyone\"")
+	}
+}
+
+// canSeeMessage reports whether the message belongs to a conversation the
+// client may read (same rule as the REST API)
+func (c *Client) canSeeMessage(msg *database.Message) bool {
+	allowed, err := c.hub.db.CanSeeMessage(msg, c.userID)
+	if err != nil {
+		log.Printf("⚠️ Failed to check access to message %d for user %d: %v", msg.ID, c.userID, err)
+		return false
+	}
+	return allowed
+}
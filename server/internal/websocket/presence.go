@@ -0,0 +1,246 @@
+package websocket
+
+import (
+	"log"
+	"time"
+
+	"e5realtimechat/internal/cache"
+)
+
+// presenceReconcileInterval is how often an instance refreshes the presence
+// of its connections and cleans up after dead instances. It must stay well
+// below cache.TTLOnlineStatus and cache.TTLInstance.
+const presenceReconcileInterval = 10 * time.Second
+
+// presenceQueueSize is how many online/offline transitions may wait for presenceLoop
+const presenceQueueSize = 1024
+
+// presenceChange is an online/offline transition of a user
+type presenceChange struct {
+	userID   int
+	username string
+	online   bool
+}
+
+// queuePresenceChange hands a transition to presenceLoop without blocking.
+// Looking up the audience and the profile takes database queries, which
+// must not stall the Hub goroutine.
+func (h *Hub) queuePresenceChange(change presenceChange) {
+	select {
+	case h.presence <- change:
+	default:
+		// Queue full: announce it on the side rather than lose it
+		log.Printf("⚠️ presence queue full, announcing user %d on its own", change.userID)
+		go h.announcePresence(change)
+	}
+}
+
+// presenceLoop saves and announces presence transitions in the order they happened
+func (h *Hub) presenceLoop() {
+	for change := range h.presence {
+		h.announcePresence(change)
+	}
+}
+
+// announcePresence records a transition and sends it to the user's audience
+func (h *Hub) announcePresence(change presenceChange) {
+	h.saveOnlineStatus(change.userID, change.online)
+	h.broadcastUserStatus(change.userID, change.username, change.online)
+}
+
+// localConnection is a connection of this instance, collected for the reconciler
+type localConnection struct {
+	client *Client
+	conn   *cache.DeviceConnection
+}
+
+// localConnections lists the connections of this instance (must run on the Hub goroutine)
+func (h *Hub) localConnections() []localConnection {
+	conns := make([]localConnection, 0, len(h.clients))
+	for client := range h.clients {
+		if client.userID > 0 {
+			conns = append(conns, localConnection{client: client, conn: client.deviceConnection(h.instanceID)})
+		}
+	}
+	return conns
+}
+
+// cleanupPreviousRun removes the connections left in Redis by a previous run
+// of this instance, e.g. a container restarted with the same HOSTNAME after a
+// crash. Called before any client registers.
+func (h *Hub) cleanupPreviousRun() {
+	h.cleanupInstance(h.instanceID)
+	if err := h.cacheService.RegisterInstance(h.instanceID); err != nil {
+		log.Printf("⚠️ Failed to register instance %s: %v", h.instanceID, err)
+	}
+}
+
+// reconcilePresence keeps the online set in line with the live connections:
+// it refreshes the presence of local connections, then marks offline the users
+// of dead instances and the users nobody refreshed in time
+func (h *Hub) reconcilePresence(local []localConnection) {
+	if err := h.cacheService.RegisterInstance(h.instanceID); err != nil {
+		log.Printf("⚠️ Failed to refresh instance %s: %v", h.instanceID, err)
+	}
+
+	// Heartbeats are sent by browsers, a live connection keeps its user online
+	// even when its heartbeats are late. Connections are recorded again in
+	// case a reconciler removed them while they were registering.
+	refreshed := make(map[int]bool)
+	for _, lc := range local {
+		if h.refreshConnection(lc, !refreshed[lc.client.userID]) {
+			refreshed[lc.client.userID] = true
+		}
+	}
+
+	dead, err := h.cacheService.GetDeadInstances()
+	if err != nil {
+		log.Printf("⚠️ Failed to list dead instances: %v", err)
+	}
+	for _, instanceID := range dead {
+		// Several instances may notice the same dead instance, one cleans up
+		if claimed, err := h.cacheService.ClaimDeadInstance(instanceID); err != nil || !claimed {
+			continue
+		}
+		log.Printf("💀 Instance %s stopped responding, cleaning up its connections", instanceID)
+		h.cleanupInstance(instanceID)
+	}
+
+	stale, err := h.cacheService.GetStaleOnlineUsers()
+	if err != nil {
+		log.Printf("⚠️ Failed to list stale online users: %v", err)
+	}
+	for _, userID := range stale {
+		h.markUserOffline(userID)
+	}
+}
+
+// refreshConnection records a local connection again, and its user as online
+// when setOnline is set, announcing them again if they were marked offline. The snapshot may be outdated: a connection released
+// meanwhile is skipped, it would otherwise linger in Redis and keep its user
+// online. Unregister waits for the write, so it can't release it halfway.
+func (h *Hub) refreshConnection(lc localConnection, setOnline bool) bool {
+	client := lc.client
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.released {
+		return false
+	}
+
+	if _, err := h.cacheService.AddUserConnection(client.userID, lc.conn); err != nil {
+		log.Printf("⚠️ Failed to refresh connection of user %d: %v", client.userID, err)
+	}
+	if setOnline {
+		restored, err := h.cacheService.RestoreUserOnline(client.userID)
+		if err != nil {
+			log.Printf("⚠️ Failed to refresh user %d online: %v", client.userID, err)
+		} else if restored {
+			// A reconciler announced them offline meanwhile
+			log.Printf("♻️ User %d (%s) is connected again, back ONLINE", client.userID, client.username)
+			h.queuePresenceChange(presenceChange{userID: client.userID, username: client.username, online: true})
+		}
+	}
+	return true
+}
+
+// clearExpiredStatuses removes the custom statuses that expired and tells
+// the audience of their users. Runs on every reconcile tick, with or without Redis.
+func (h *Hub) clearExpiredStatuses() {
+	if h.db == nil {
+		return
+	}
+	userIDs, err := h.db.ClearExpiredStatuses()
+	if err != nil {
+		log.Printf("⚠️ Failed to clear expired statuses: %v", err)
+		return
+	}
+	for _, userID := range userIDs {
+		if h.cacheService != nil {
+			if err := h.cacheService.InvalidateUserProfile(userID); err != nil {
+				log.Printf("⚠️ Failed to invalidate profile of user %d: %v", userID, err)
+			}
+		}
+		username := ""
+		if profile := h.userProfile(userID); profile != nil {
+			username = profile.Username
+		}
+		log.Printf("⌛ Custom status of user %d (%s) expired", userID, username)
+		h.NotifyUserStatus(userID, username)
+	}
+}
+
+// userProfile returns the profile of a user from the profile cache, loading
+// it from the database on a miss. Returns nil when it can't be loaded.
+func (h *Hub) userProfile(userID int) *cache.UserProfile {
+	if h.cacheService != nil {
+		if profile, err := h.cacheService.GetUserProfile(userID); err == nil {
+			return profile
+		}
+	}
+	if h.db == nil {
+		return nil
+	}
+
+	user, err := h.db.GetUserProfile(userID)
+	if err != nil {
+		log.Printf("⚠️ Failed to load profile of user %d: %v", userID, err)
+		return nil
+	}
+	profile := &cache.UserProfile{
+		ID:              user.ID,
+		Username:        user.Username,
+		AvatarURL:       user.AvatarURL,
+		StatusText:      user.StatusText,
+		StatusEmoji:     user.StatusEmoji,
+		StatusExpiresAt: user.StatusExpiresAt,
+	}
+	if h.cacheService != nil {
+		if err := h.cacheService.SetUserProfile(userID, profile); err != nil {
+			log.Printf("⚠️ Failed to cache profile of user %d: %v", userID, err)
+		}
+	}
+	return profile
+}
+
+// cleanupInstance removes the connections recorded by an instance and marks
+// offline the users left without any connection
+func (h *Hub) cleanupInstance(instanceID string) {
+	disconnected, err := h.cacheService.RemoveInstanceConnections(instanceID)
+	if err != nil {
+		log.Printf("⚠️ Failed to clean up connections of instance %s: %v", instanceID, err)
+	}
+	for _, userID := range disconnected {
+		h.markUserOffline(userID)
+	}
+}
+
+// markUserOffline marks a user whose connections are gone as offline, records
+// when they were last seen and notifies their audience
+func (h *Hub) markUserOffline(userID int) {
+	claimed, err := h.cacheService.ClaimStaleUser(userID)
+	if err != nil {
+		log.Printf("⚠️ Failed to mark user %d offline: %v", userID, err)
+		return
+	}
+	if !claimed {
+		return // already offline, or handled by another instance
+	}
+
+	username := ""
+	if profile := h.userProfile(userID); profile != nil {
+		username = profile.Username
+	}
+	log.Printf("🧹 User %d (%s) had no live connection, now OFFLINE", userID, username)
+
+	h.queuePresenceChange(presenceChange{userID: userID, username: username, online: false})
+}
+
+// saveOnlineStatus writes users.is_online and users.last_seen_at
+func (h *Hub) saveOnlineStatus(userID int, isOnline bool) {
+	if h.db == nil {
+		return
+	}
+	if err := h.db.UpdateUserOnlineStatus(userID, isOnline); err != nil {
+		log.Printf("⚠️ Failed to save online status of user %d: %v", userID, err)
+	}
+}
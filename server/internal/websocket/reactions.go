@@ -0,0 +1,91 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	"e5realtimechat/internal/database"
+)
+
+// maxReactionLength matches message_reactions.emoji VARCHAR(32)
+const maxReactionLength = 32
+
+// reactionEvent tells conversation participants that a reaction was added or removed
+type reactionEvent struct {
+	messageEvent        // "reaction_added" or "reaction_removed"
+	UserID       int    `json:"user_id"` // who reacted
+	Emoji        string `json:"emoji"`
+	Count        int    `json:"count"` // users with this emoji after the change
+}
+
+// handleReactionFrame processes react and unreact frames
+func (c *Client) handleReactionFrame(wsMsg WSMessage) {
+	emoji := strings.TrimSpace(wsMsg.Emoji)
+	if wsMsg.MessageID <= 0 || emoji == "" {
+		c.sendError(wsMsg.Type + " requires message_id and emoji")
+		return
+	}
+	if utf8.RuneCountInString(emoji) > maxReactionLength {
+		c.sendError("emoji is too long")
+		return
+	}
+	if c.hub.db == nil {
+		c.sendError("Reactions are not available")
+		return
+	}
+
+	msg, err := c.hub.db.GetMessageByID(wsMsg.MessageID)
+	if err != nil || !c.canSeeMessage(msg) {
+		c.sendError("Cannot react: " + database.ErrMessageNotFound.Error())
+		return
+	}
+	if msg.DeletedAt != nil {
+		c.sendError("Cannot react: " + database.ErrMessageDeleted.Error())
+		return
+	}
+	if c.blockedWith(c.messagePeer(msg)) {
+		c.sendError("You cannot message this user")
+		return
+	}
+
+	var changed bool
+	eventType := "reaction_added"
+	if wsMsg.Type == "unreact" {
+		eventType = "reaction_removed"
+		changed, err = c.hub.db.RemoveReaction(msg.ID, c.userID, emoji)
+	} else {
+		changed, err = c.hub.db.AddReaction(msg.ID, c.userID, emoji)
+	}
+	if err != nil {
+		log.Printf("⚠️ User %d failed to %s message %d: %v", c.userID, wsMsg.Type, msg.ID, err)
+		c.sendError("Failed to update reaction")
+		return
+	}
+	if !changed {
+		return // already in the requested state
+	}
+
+	count, err := c.hub.db.CountReactions(msg.ID, emoji)
+	if err != nil {
+		log.Printf("⚠️ Failed to count reactions of message %d: %v", msg.ID, err)
+	}
+	c.hub.NotifyReaction(msg, reactionEvent{
+		messageEvent: newMessageEvent(eventType, msg),
+		UserID:       c.userID,
+		Emoji:        emoji,
+		Count:        count,
+	})
+}
+
+// NotifyReaction pushes a reaction change to the message's conversation.
+// Reactions are reloaded with the history, so they are not replayed.
+func (h *Hub) NotifyReaction(msg *database.Message, event reactionEvent) {
+	eventBytes, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("⚠️ Failed to marshal %s event: %v", event.Type, err)
+		return
+	}
+	h.multicastToConversation(msg, eventBytes)
+}
@@ -0,0 +1,78 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"e5realtimechat/internal/database"
+)
+
+// readReceiptEvent is pushed to both sides of a conversation when messages are read
+type readReceiptEvent struct {
+	Type string `json:"type"` // "read_receipt"
+	*database.ReadReceipt
+}
+
+// SendReadReceipt notifies the original sender that their messages were read,
+// and the reader's other connections so their unread counters stay in sync.
+// Read state is reloaded over REST, so receipts are not replayed.
+func (h *Hub) SendReadReceipt(receipt *database.ReadReceipt) {
+	if receipt == nil || receipt.Count == 0 {
+		return
+	}
+
+	eventBytes, err := json.Marshal(readReceiptEvent{Type: "read_receipt", ReadReceipt: receipt})
+	if err != nil {
+		log.Printf("⚠️ Failed to marshal read receipt: %v", err)
+		return
+	}
+
+	h.multicast(eventBytes, []int{receipt.SenderID, receipt.ReaderID})
+}
+
+// handleReadFrame marks a direct conversation as read up to wsMsg.MessageID
+func (c *Client) handleReadFrame(wsMsg WSMessage) {
+	if wsMsg.ToUserID <= 0 || wsMsg.MessageID <= 0 {
+		c.sendError("read requires to_user_id and message_id")
+		return
+	}
+	if c.hub.db == nil {
+		c.sendError("Read receipts are not available")
+		return
+	}
+
+	if c.hub.rateLimiter != nil && c.userID > 0 {
+		allowed, err := c.hub.rateLimiter.CheckUserReadRate(c.userID)
+		if err != nil {
+			log.Printf("⚠️ Read rate limit check error for user %d: %v", c.userID, err)
+		} else if !allowed {
+			c.sendError("Rate limit exceeded. Please slow down.")
+			return
+		}
+	}
+
+	// Checked without the per-connection cache: blocking keeps the messages,
+	// and a receipt sent right after a block would still reach the blocker
+	blocked, err := c.hub.db.IsBlocked(c.userID, wsMsg.ToUserID)
+	if err != nil {
+		log.Printf("❌ Error checking block between %d and %d: %v", c.userID, wsMsg.ToUserID, err)
+		c.sendError("Failed to mark messages as read")
+		return
+	}
+	c.blocks[wsMsg.ToUserID] = blockCheck{blocked: blocked, checkedAt: time.Now()}
+	if blocked {
+		c.sendError("You cannot message this user")
+		return
+	}
+
+	receipt, err := c.hub.db.MarkConversationRead(c.userID, wsMsg.ToUserID, wsMsg.MessageID)
+	if err != nil {
+		log.Printf("❌ Error marking messages read for user %d: %v", c.userID, err)
+		c.sendError("Failed to mark messages as read")
+		return
+	}
+
+	log.Printf("👀 User %d read %d message(s) from user %d", c.userID, receipt.Count, wsMsg.ToUserID)
+	c.hub.SendReadReceipt(receipt)
+}
@@ -0,0 +1,186 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"e5realtimechat/internal/database"
+)
+
+// RoomMessage contains message and target room ID
+type RoomMessage struct {
+	message []byte
+	roomID  int
+}
+
+// roomSubscription asks the Hub to add/remove a client from a room index
+type roomSubscription struct {
+	client *Client
+	roomID int
+}
+
+// SendRoomMessage sends a message to every member of a room subscribed on any instance
+func (h *Hub) SendRoomMessage(message []byte, roomID int) {
+	if err := h.RoomViaRedis(message, roomID); err != nil {
+		log.Printf("⚠️ Failed to route room message via Redis: %v", err)
+	}
+}
+
+// RoomViaRedis publishes a room message to Redis so that every instance can
+// fan it out to its locally subscribed clients
+func (h *Hub) RoomViaRedis(message []byte, roomID int) error {
+	if h.redisClient == nil {
+		// Fallback to local delivery only
+		h.deliverRoomLocal(message, roomID)
+		return nil
+	}
+
+	envelope, err := json.Marshal(redisEnvelope{
+		Origin:  h.instanceID,
+		RoomID:  roomID,
+		Payload: message,
+	})
+	if err != nil {
+		h.deliverRoomLocal(message, roomID)
+		return fmt.Errorf("failed to marshal room envelope: %w", err)
+	}
+
+	if err := h.redisClient.Publish(redisRoomMsgChannel, string(envelope)); err != nil {
+		log.Printf("⚠️ Failed to publish room message to Redis: %v", err)
+		// Fallback to local delivery
+		h.deliverRoomLocal(message, roomID)
+		return err
+	}
+
+	// Also deliver to local clients immediately (our own echo is skipped)
+	h.deliverRoomLocal(message, roomID)
+	return nil
+}
+
+// deliverRoomLocal queues a room message for clients connected to this
+// instance. Room messages are not replayed, so it waits for room in the queue
+// rather than lose the frame; it must not be called from the Hub goroutine.
+func (h *Hub) deliverRoomLocal(message []byte, roomID int) {
+	h.roomMsg <- &RoomMessage{message: message, roomID: roomID}
+}
+
+// removeFromRoom removes a client from a room index (must run on the Hub goroutine)
+func (h *Hub) removeFromRoom(client *Client, roomID int) {
+	members, ok := h.rooms[roomID]
+	if !ok {
+		return
+	}
+	delete(members, client)
+	if len(members) == 0 {
+		delete(h.rooms, roomID)
+	}
+}
+
+// removeFromAllRooms removes a client from every room it joined (must run on the Hub goroutine)
+func (h *Hub) removeFromAllRooms(client *Client) {
+	for _, roomID := range client.joinedRooms() {
+		h.removeFromRoom(client, roomID)
+	}
+}
+
+// joinRoom validates membership and subscribes the client to a room.
+// Public rooms are open to everyone, so joining one adds the user as a member.
+func (c *Client) joinRoom(roomID int) error {
+	if c.hub.db == nil {
+		return errors.New("rooms are not available")
+	}
+
+	room, err := c.hub.db.GetRoomByID(roomID)
+	if err != nil {
+		return err
+	}
+
+	banned, err := c.hub.db.IsRoomBanned(roomID, c.userID)
+	if err != nil {
+		return err
+	}
+	if banned {
+		return database.ErrRoomBanned
+	}
+
+	isMember, err := c.hub.db.IsRoomMember(roomID, c.userID)
+	if err != nil {
+		return err
+	}
+	if !isMember {
+		if room.RoomType != "public" {
+			return database.ErrNotRoomMember
+		}
+		if err := c.hub.db.AddRoomMember(roomID, c.userID, "member"); err != nil {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	c.rooms[roomID] = true
+	c.mu.Unlock()
+
+	c.hub.roomJoin <- &roomSubscription{client: c, roomID: roomID}
+	return nil
+}
+
+// leaveRoom unsubscribes the client from a room (membership is kept)
+func (c *Client) leaveRoom(roomID int) {
+	c.mu.Lock()
+	delete(c.rooms, roomID)
+	c.mu.Unlock()
+
+	c.hub.roomLeave <- &roomSubscription{client: c, roomID: roomID}
+}
+
+// inRoom reports whether the client has joined a room
+func (c *Client) inRoom(roomID int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rooms[roomID]
+}
+
+// joinedRooms returns the IDs of rooms the client has joined
+func (c *Client) joinedRooms() []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ids := make([]int, 0, len(c.rooms))
+	for roomID := range c.rooms {
+		ids = append(ids, roomID)
+	}
+	return ids
+}
+
+// checkRoomPost verifies the client may post to a room right now.
+// Membership, bans and mutes change from the REST API and other instances,
+// so the database is the source of truth rather than the local subscription.
+func (c *Client) checkRoomPost(roomID int) error {
+	if !c.inRoom(roomID) {
+		return errors.New("join the room before sending messages to it")
+	}
+	if c.hub.db == nil {
+		return nil
+	}
+	return c.hub.db.CheckRoomPostPermission(roomID, c.userID)
+}
+
+// saveRoomMessage persists a room message with room_id set
+func (c *Client) saveRoomMessage(roomID int, text, clientMsgID string, replyToID, threadRootID int) (*database.Message, error) {
+	if c.hub.db == nil {
+		return nil, errors.New("database not configured")
+	}
+	msg := &database.Message{
+		Type:        "message",
+		FromUserID:  c.userID,
+		RoomID:      &roomID,
+		Text:        text,
+		ClientMsgID: clientMsgID,
+	}
+	msg.SetReply(replyToID, threadRootID)
+	if err := c.hub.db.SaveMessage(msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
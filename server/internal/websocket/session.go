@@ -0,0 +1,320 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+
+	"e5realtimechat/internal/database"
+)
+
+// Every frame sent to a user through SendDirectMessage gets a per-user,
+// monotonically increasing "seq" and is kept in Redis for a while. A client
+// reconnecting with ?last_seq=N gets the frames it missed replayed before any
+// live frame. Only durable frames (chat messages, edits, deletions,
+// notifications) go through SendDirectMessage: typing, read receipts,
+// reactions and presence are multicast unsequenced, so they don't use up
+// the replay window. Room messages are not sequenced: clients catch up on
+// rooms with the history API (after_id).
+//
+// Direct messages sent while a user had no connection stay undelivered
+// (messages.delivered_at IS NULL) and are flushed when the user connects again.
+
+// maxOfflineFlush caps the undelivered messages pushed on connect. Older ones
+// are flushed on the next connect, unread_counts always covers everything.
+const maxOfflineFlush = 100
+
+// sessionFrame is the first frame of every session: a summary of what the
+// user missed, followed by the replayed or offline frames
+type sessionFrame struct {
+	Type         string      `json:"type"`     // "session"
+	LastSeq      int64       `json:"last_seq"` // newest seq assigned to the user when the session started
+	Resumed      bool        `json:"resumed"`  // client sent last_seq
+	Replayed     int         `json:"replayed,omitempty"`
+	Gap          bool        `json:"gap,omitempty"`       // some missed frames are no longer stored, reload over REST
+	Delivered    int         `json:"delivered,omitempty"` // offline messages flushed after this frame
+	UnreadCounts map[int]int `json:"unread_counts"`       // sender user ID → unread direct messages
+	TotalUnread  int         `json:"total_unread"`
+}
+
+// replayResult carries what was loaded for a (re)connecting client
+type replayResult struct {
+	client      *Client
+	frames      []string
+	lastSeq     int64
+	err         error
+	undelivered []*database.Message
+	unread      map[int]int
+}
+
+// sequenceFrame assigns the next seq of a user to a JSON frame and stores it
+// for replay. Frames are returned unchanged when sequencing is unavailable.
+func (h *Hub) sequenceFrame(message []byte, userID int) []byte {
+	if h.cacheService == nil {
+		return message
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(message, &fields); err != nil {
+		return message // not a JSON object
+	}
+
+	seq, err := h.cacheService.NextUserSeq(userID)
+	if err != nil {
+		log.Printf("⚠️ Failed to assign seq for user %d: %v", userID, err)
+		return message
+	}
+	fields["seq"] = json.RawMessage(strconv.FormatInt(seq, 10))
+
+	framed, err := json.Marshal(fields)
+	if err != nil {
+		return message
+	}
+
+	if err := h.cacheService.AppendReplayFrame(userID, seq, framed); err != nil {
+		log.Printf("⚠️ Failed to store frame %d for user %d: %v", seq, userID, err)
+	}
+	return framed
+}
+
+// frameSeq returns the seq of a frame, 0 if absent
+func frameSeq(message []byte) int64 {
+	var frame struct {
+		Seq int64 `json:"seq"`
+	}
+	if err := json.Unmarshal(message, &frame); err != nil {
+		return 0
+	}
+	return frame.Seq
+}
+
+// chatMessageID returns the message_id of a "message" frame, 0 for any other
+// frame (edits, reactions... carry the message_id of the message they change)
+func chatMessageID(message []byte) int {
+	var frame struct {
+		Type      string `json:"type"`
+		MessageID int    `json:"message_id"`
+	}
+	if err := json.Unmarshal(message, &frame); err != nil || frame.Type != "message" {
+		return 0
+	}
+	return frame.MessageID
+}
+
+// sessionBacklog picks what is sent after the session frame, within limit
+// frames so the client's send buffer can't overflow: the newest replayed
+// frames, and before them the oldest offline messages that were not replayed.
+// It returns the IDs of the undelivered messages that were sent or replayed,
+// and whether replayed frames were left out (a gap the client must reload
+// over REST).
+func sessionBacklog(replayed [][]byte, undelivered []*database.Message, limit int) ([][]byte, []*database.Message, []int, bool) {
+	if limit < 0 {
+		limit = 0
+	}
+	trimmed := false
+	if len(replayed) > limit {
+		replayed = replayed[len(replayed)-limit:]
+		trimmed = true
+	}
+
+	inReplay := make(map[int]bool, len(replayed))
+	for _, f := range replayed {
+		if messageID := chatMessageID(f); messageID > 0 {
+			inReplay[messageID] = true
+		}
+	}
+
+	room := limit - len(replayed)
+	var offline []*database.Message
+	var delivered []int
+	for _, msg := range undelivered {
+		if inReplay[msg.ID] {
+			delivered = append(delivered, msg.ID)
+			continue
+		}
+		if len(offline) < room {
+			// Others are left undelivered and flushed on the next connect
+			offline = append(offline, msg)
+			delivered = append(delivered, msg.ID)
+		}
+	}
+	return replayed, offline, delivered, trimmed
+}
+
+// startSession holds direct frames for a newly registered client while its
+// missed frames are loaded (must run on the Hub goroutine)
+func (h *Hub) startSession(client *Client) {
+	if (h.cacheService == nil && h.db == nil) || client.userID <= 0 {
+		return
+	}
+	client.syncing = true
+	go h.loadReplay(client)
+}
+
+// loadReplay reads what a client missed and hands it back to Run
+func (h *Hub) loadReplay(client *Client) {
+	result := &replayResult{client: client}
+	if h.cacheService != nil {
+		result.lastSeq, result.err = h.cacheService.GetUserSeq(client.userID)
+		if result.err == nil && client.resumeFrom >= 0 {
+			result.frames, result.err = h.cacheService.GetReplayFrames(client.userID, client.resumeFrom)
+		}
+	}
+	if h.db != nil {
+		var err error
+		if result.undelivered, err = h.db.GetUndeliveredMessages(client.userID, maxOfflineFlush); err != nil {
+			log.Printf("⚠️ Failed to load undelivered messages for user %d: %v", client.userID, err)
+		}
+		if result.unread, err = h.db.GetUnreadCounts(client.userID); err != nil {
+			log.Printf("⚠️ Failed to load unread counts for user %d: %v", client.userID, err)
+		}
+	}
+	h.replayDone <- result
+}
+
+// finishSession sends the session frame, then the offline messages that were
+// not replayed, then the replayed frames (resume), then the frames held
+// meanwhile, skipping duplicates (must run on the Hub goroutine)
+func (h *Hub) finishSession(result *replayResult) {
+	client := result.client
+	if _, ok := h.clients[client]; !ok {
+		return // client disconnected during the replay
+	}
+	if result.err != nil {
+		log.Printf("⚠️ Failed to load replay for user %d: %v", client.userID, result.err)
+	}
+
+	// A last_seq ahead of the counter means the counter restarted (Redis was
+	// flushed or restarted): the client's seq no longer matches ours, so this
+	// is a new session and whatever it missed must be reloaded over REST
+	seqReset := result.err == nil && client.resumeFrom > result.lastSeq
+	if seqReset {
+		log.Printf("⚠️ User %d resumed from seq %d but the counter is at %d, starting a new session", client.userID, client.resumeFrom, result.lastSeq)
+		client.resumeFrom = -1
+		result.frames = nil
+	}
+
+	frame := sessionFrame{
+		Type:         "session",
+		LastSeq:      result.lastSeq,
+		Resumed:      client.resumeFrom >= 0 && h.cacheService != nil,
+		Gap:          seqReset,
+		UnreadCounts: make(map[int]int),
+	}
+	for fromUserID, count := range result.unread {
+		frame.UnreadCounts[fromUserID] = count
+		frame.TotalUnread += count
+	}
+
+	replayed := make([][]byte, 0, len(result.frames))
+	maxSeq := client.resumeFrom
+	for _, f := range result.frames {
+		seq := frameSeq([]byte(f))
+		if seq <= maxSeq {
+			continue
+		}
+		if len(replayed) == 0 && seq > client.resumeFrom+1 {
+			frame.Gap = true // oldest missed frames were trimmed
+		}
+		replayed = append(replayed, []byte(f))
+		maxSeq = seq
+	}
+	if frame.Resumed {
+		frame.Replayed = len(replayed)
+		if result.err != nil || (len(replayed) == 0 && result.lastSeq > client.resumeFrom) {
+			frame.Gap = true
+		}
+	}
+
+	// Offline messages already in the replay are not sent twice. Room is kept
+	// in the send buffer for the session frame and the frames held meanwhile.
+	limit := cap(client.send) - len(client.send) - 1 - len(client.pending)
+	replayed, offlineMsgs, delivered, trimmed := sessionBacklog(replayed, result.undelivered, limit)
+	if trimmed {
+		frame.Gap = true
+		frame.Replayed = len(replayed)
+	}
+
+	flushed := make(map[int]bool)
+	var offline [][]byte
+	for _, msg := range offlineMsgs {
+		msgBytes, err := json.Marshal(messageFrame(msg, msg.FromUsername))
+		if err != nil {
+			continue
+		}
+		offline = append(offline, msgBytes)
+		flushed[msg.ID] = true
+	}
+	frame.Delivered = len(offline)
+
+	frameBytes, err := json.Marshal(frame)
+	if err == nil && !h.sendToClient(client, frameBytes) {
+		return
+	}
+	// Offline messages go first, in ID order: the replay holds the newer frames
+	for _, f := range offline {
+		if !h.sendToClient(client, f) {
+			return
+		}
+	}
+	for _, f := range replayed {
+		if !h.sendToClient(client, f) {
+			return
+		}
+	}
+
+	if len(delivered) > 0 {
+		userID := client.userID
+		go func() {
+			if err := h.db.MarkMessagesDelivered(userID, delivered); err != nil {
+				log.Printf("⚠️ Failed to mark messages delivered for user %d: %v", userID, err)
+			}
+		}()
+	}
+
+	pending := client.pending
+	client.pending = nil
+	client.syncing = false
+	for _, f := range pending {
+		seq := frameSeq(f)
+		if (seq > 0 && seq <= maxSeq) || flushed[chatMessageID(f)] {
+			continue // already replayed or flushed
+		}
+		if !h.sendToClient(client, f) {
+			return
+		}
+	}
+
+	if frame.Resumed {
+		log.Printf("🔁 User %d (%s) resumed from seq %d: replayed %d frame(s), gap=%v", client.userID, client.username, client.resumeFrom, frame.Replayed, frame.Gap)
+	}
+	if frame.Delivered > 0 {
+		log.Printf("📬 Delivered %d offline message(s) to user %d (%s)", frame.Delivered, client.userID, client.username)
+	}
+}
+
+// sendToClient queues a frame for a client, dropping the client if its buffer
+// is full (must run on the Hub goroutine)
+func (h *Hub) sendToClient(client *Client, message []byte) bool {
+	select {
+	case client.send <- message:
+		return true
+	default:
+		log.Printf("❌ Failed to send to client %d (%s), channel blocked. Closing connection.", client.userID, client.username)
+		h.dropClient(client)
+		return false
+	}
+}
+
+// dropClient stops routing frames to a client and tells its writePump to
+// flush what is queued and close the connection, which then unregisters the
+// client. send stays open: readPump may still be writing to it, only
+// unregister closes it (must run on the Hub goroutine).
+func (h *Hub) dropClient(client *Client) {
+	if _, ok := h.clients[client]; !ok {
+		return
+	}
+	delete(h.clients, client)
+	h.removeFromAllRooms(client)
+	close(client.done)
+}
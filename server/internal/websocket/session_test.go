@@ -0,0 +1,184 @@
+package websocket
+
+import (
+	"encoding/json"
+	"testing"
+
+	"e5realtimechat/internal/database"
+)
+
+func replayFrame(t *testing.T, seq int64, frameType string, messageID int) []byte {
+	t.Helper()
+	frame, err := json.Marshal(map[string]interface{}{
+		"type":       frameType,
+		"seq":        seq,
+		"message_id": messageID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frame
+}
+
+func undeliveredMessages(ids ...int) []*database.Message {
+	messages := make([]*database.Message, 0, len(ids))
+	for _, id := range ids {
+		messages = append(messages, &database.Message{ID: id})
+	}
+	return messages
+}
+
+func messageIDs(messages []*database.Message) []int {
+	ids := make([]int, 0, len(messages))
+	for _, msg := range messages {
+		ids = append(ids, msg.ID)
+	}
+	return ids
+}
+
+func frameSeqs(frames [][]byte) []int64 {
+	seqs := make([]int64, 0, len(frames))
+	for _, f := range frames {
+		seqs = append(seqs, frameSeq(f))
+	}
+	return seqs
+}
+
+func equalInts[T int | int64](a, b []T) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSessionBacklog(t *testing.T) {
+	tests := []struct {
+		name          string
+		replayed      [][]byte
+		undelivered   []*database.Message
+		limit         int
+		wantSeqs      []int64
+		wantOffline   []int
+		wantDelivered []int
+		wantTrimmed   bool
+	}{
+		{
+			name:          "new session sends every offline message",
+			undelivered:   undeliveredMessages(10, 11, 12),
+			limit:         100,
+			wantSeqs:      []int64{},
+			wantOffline:   []int{10, 11, 12},
+			wantDelivered: []int{10, 11, 12},
+		},
+		{
+			name: "offline messages already replayed are skipped",
+			replayed: [][]byte{
+				replayFrame(t, 5, "message", 11),
+				replayFrame(t, 6, "message", 12),
+			},
+			undelivered:   undeliveredMessages(10, 11, 12),
+			limit:         100,
+			wantSeqs:      []int64{5, 6},
+			wantOffline:   []int{10},
+			wantDelivered: []int{10, 11, 12},
+		},
+		{
+			name: "only message frames count as replayed",
+			replayed: [][]byte{
+				replayFrame(t, 5, "message_edited", 10),
+				replayFrame(t, 6, "reaction_added", 11),
+			},
+			undelivered:   undeliveredMessages(10, 11),
+			limit:         100,
+			wantSeqs:      []int64{5, 6},
+			wantOffline:   []int{10, 11},
+			wantDelivered: []int{10, 11},
+		},
+		{
+			name:          "offline messages over the limit stay undelivered",
+			undelivered:   undeliveredMessages(10, 11, 12, 13),
+			limit:         2,
+			wantSeqs:      []int64{},
+			wantOffline:   []int{10, 11},
+			wantDelivered: []int{10, 11},
+		},
+		{
+			name: "replayed messages after the limit are still marked delivered",
+			replayed: [][]byte{
+				replayFrame(t, 7, "message", 11),
+			},
+			undelivered:   undeliveredMessages(10, 11, 12),
+			limit:         2,
+			wantSeqs:      []int64{7},
+			wantOffline:   []int{10},
+			wantDelivered: []int{10, 11},
+		},
+		{
+			name: "replay over the limit keeps the newest frames and marks them delivered",
+			replayed: [][]byte{
+				replayFrame(t, 1, "message", 20),
+				replayFrame(t, 2, "message", 21),
+				replayFrame(t, 3, "message", 22),
+			},
+			undelivered:   undeliveredMessages(20, 21, 22),
+			limit:         2,
+			wantSeqs:      []int64{2, 3},
+			wantOffline:   []int{},
+			wantDelivered: []int{21, 22},
+			wantTrimmed:   true,
+		},
+		{
+			name:          "no room at all",
+			replayed:      [][]byte{replayFrame(t, 1, "message", 20)},
+			undelivered:   undeliveredMessages(19),
+			limit:         -3,
+			wantSeqs:      []int64{},
+			wantOffline:   []int{},
+			wantDelivered: []int{},
+			wantTrimmed:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replayed, offline, delivered, trimmed := sessionBacklog(tt.replayed, tt.undelivered, tt.limit)
+			if got := frameSeqs(replayed); !equalInts(got, tt.wantSeqs) {
+				t.Errorf("replayed seqs = %v, want %v", got, tt.wantSeqs)
+			}
+			if got := messageIDs(offline); !equalInts(got, tt.wantOffline) {
+				t.Errorf("offline IDs = %v, want %v", got, tt.wantOffline)
+			}
+			if !equalInts(delivered, tt.wantDelivered) {
+				t.Errorf("delivered IDs = %v, want %v", delivered, tt.wantDelivered)
+			}
+			if trimmed != tt.wantTrimmed {
+				t.Errorf("trimmed = %v, want %v", trimmed, tt.wantTrimmed)
+			}
+		})
+	}
+}
+
+func TestSessionBacklogFitsSendBuffer(t *testing.T) {
+	replayed := make([][]byte, 0, 200)
+	for seq := int64(1); seq <= 200; seq++ {
+		replayed = append(replayed, replayFrame(t, seq, "message", int(seq)))
+	}
+	undelivered := make([]*database.Message, 0, 100)
+	for id := 1000; id < 1100; id++ {
+		undelivered = append(undelivered, &database.Message{ID: id})
+	}
+
+	limit := 256 - 1 // session frame
+	replayedOut, offline, _, _ := sessionBacklog(replayed, undelivered, limit)
+	if total := len(replayedOut) + len(offline); total > limit {
+		t.Fatalf("backlog of %d frames exceeds the limit of %d", total, limit)
+	}
+	if len(replayedOut) != 200 || len(offline) != 55 {
+		t.Errorf("got %d replayed and %d offline, want 200 and 55", len(replayedOut), len(offline))
+	}
+}
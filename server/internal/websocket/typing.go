@@ -0,0 +1,122 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// typingRefreshInterval is how often a repeated typing_start for the same
+// conversation is forwarded. Clients send typing_start on every keystroke,
+// extra frames inside the interval are swallowed without touching the rate limit.
+const typingRefreshInterval = 3 * time.Second
+
+// typingTarget identifies the conversation a client is typing in
+type typingTarget struct {
+	toUserID int
+	roomID   int
+}
+
+// handleTypingFrame processes typing_start / typing_stop frames.
+// Only the readPump goroutine touches c.typing, so no lock is needed.
+func (c *Client) handleTypingFrame(wsMsg WSMessage) {
+	var target typingTarget
+	switch {
+	case wsMsg.ToUserID > 0:
+		if wsMsg.ToUserID == c.userID {
+			return
+		}
+		target.toUserID = wsMsg.ToUserID
+	case wsMsg.RoomID > 0:
+		if !c.inRoom(wsMsg.RoomID) {
+			c.sendError("Join the room before sending typing indicators")
+			return
+		}
+		target.roomID = wsMsg.RoomID
+	default:
+		c.sendError("Typing indicator must have a to_user_id or room_id")
+		return
+	}
+
+	if wsMsg.Type == "typing_stop" {
+		c.stopTyping(target)
+		return
+	}
+
+	if last, ok := c.typing[target]; ok && time.Since(last) < typingRefreshInterval {
+		return // throttled, peers already know
+	}
+
+	if c.hub.rateLimiter != nil && c.userID > 0 {
+		allowed, err := c.hub.rateLimiter.CheckUserTypingRate(c.userID)
+		if err != nil {
+			log.Printf("⚠️ Typing rate limit check error for user %d: %v", c.userID, err)
+		} else if !allowed {
+			return // typing indicators are best effort, drop silently
+		}
+	}
+
+	// Same gates as chat messages: friends only for direct typing,
+	// no muted or banned members in rooms
+	if !c.canSendTyping(target) {
+		return
+	}
+
+	c.typing[target] = time.Now()
+	c.sendTypingEvent("typing_start", target)
+}
+
+// canSendTyping checks the client may send chat messages to the conversation
+func (c *Client) canSendTyping(target typingTarget) bool {
+	if target.roomID > 0 {
+		return c.checkRoomPost(target.roomID) == nil
+	}
+	if c.hub.db == nil {
+		return true
+	}
+	allowed, err := c.hub.db.CanSendDirectMessage(c.userID, target.toUserID)
+	if err != nil {
+		log.Printf("⚠️ Failed to check typing permission from %d to %d: %v", c.userID, target.toUserID, err)
+		return false
+	}
+	return allowed
+}
+
+// stopTyping tells peers the client stopped typing in a conversation
+func (c *Client) stopTyping(target typingTarget) {
+	if _, ok := c.typing[target]; !ok {
+		return
+	}
+	delete(c.typing, target)
+	c.sendTypingEvent("typing_stop", target)
+}
+
+// stopAllTyping clears every typing state of the client (on disconnect)
+func (c *Client) stopAllTyping() {
+	for target := range c.typing {
+		c.stopTyping(target)
+	}
+}
+
+// sendTypingEvent delivers a typing event to the peer or the room only
+func (c *Client) sendTypingEvent(eventType string, target typingTarget) {
+	event := WSMessage{
+		Type:       eventType,
+		From:       c.username,
+		FromUserID: c.userID,
+		ToUserID:   target.toUserID,
+		RoomID:     target.roomID,
+	}
+	eventBytes, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("⚠️ Failed to marshal typing event: %v", err)
+		return
+	}
+
+	if target.roomID > 0 {
+		c.hub.SendRoomMessage(eventBytes, target.roomID)
+		return
+	}
+	// Stale once missed, never replayed
+	c.hub.multicast(eventBytes, []int{target.toUserID})
+}
@@ -6,7 +6,9 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"e5realtimechat/internal/auth"
 	"e5realtimechat/internal/cache"
@@ -36,6 +38,21 @@ type Message struct {
 	Value float64 `json:"value,omitempty"`
 }
 
+// roomMemberActions are the moderation actions exposed under /api/rooms/members/
+var roomMemberActions = []string{
+	handlers.ActionInvite,
+	handlers.ActionKick,
+	handlers.ActionBan,
+	handlers.ActionUnban,
+	handlers.ActionMute,
+	handlers.ActionUnmute,
+	handlers.ActionPromote,
+	handlers.ActionDemote,
+}
+
+// maxDeviceLength caps the device label of a WebSocket connection
+const maxDeviceLength = 200
+
 // serveWs handles WebSocket requests from the peer (with authentication).
 func serveWs(hub *websocket.Hub, authService *auth.AuthService, w http.ResponseWriter, r *http.Request) {
 	// Validate token from query parameter or header
@@ -71,6 +88,12 @@ func serveWs(hub *websocket.Hub, authService *auth.AuthService, w http.ResponseW
 		return
 	}
 
+	if auth.IsSessionRevoked(claims.SessionID) {
+		http.Error(w, "Unauthorized: session revoked", http.StatusUnauthorized)
+		log.Println("❌ WebSocket connection rejected: session revoked")
+		return
+	}
+
 	// Get user info
 	user, err := authService.GetUserByID(claims.UserID)
 	if err != nil {
@@ -88,8 +111,25 @@ func serveWs(hub *websocket.Hub, authService *auth.AuthService, w http.ResponseW
 
 	log.Printf("✅ WebSocket connected: userID=%d, username=%s", user.ID, user.Username)
 
+	// Resume a previous session if the client sent the last seq it received
+	lastSeq := int64(-1)
+	if seqStr := r.URL.Query().Get("last_seq"); seqStr != "" {
+		if seq, err := strconv.ParseInt(seqStr, 10, 64); err == nil && seq >= 0 {
+			lastSeq = seq
+		}
+	}
+
+	// Device label shown in the user's device list, the app may name itself
+	device := r.URL.Query().Get("device")
+	if device == "" {
+		device = r.UserAgent()
+	}
+	if len(device) > maxDeviceLength {
+		device = strings.ToValidUTF8(device[:maxDeviceLength], "")
+	}
+
 	// Create client with user info
-	client := hub.Register(conn, user.ID, user.Username)
+	client := hub.Register(conn, user.ID, user.Username, lastSeq, device, claims.SessionID)
 
 	// Start write pump in a goroutine, run read pump on this goroutine
 	// so that when readPump returns, we can exit the handler cleanly.
@@ -210,6 +250,18 @@ func main() {
 	// Set save message function for websocket
 	websocket.SetSaveMessageFunc(handlers.SaveMessageToDB)
 
+	// How long authors can edit their messages (e.g. "15m", "0" = no limit)
+	if window, err := time.ParseDuration(getEnv("MESSAGE_EDIT_WINDOW", "15m")); err == nil && window >= 0 {
+		database.MessageEditWindow = window
+	} else {
+		log.Printf("⚠️ Invalid MESSAGE_EDIT_WINDOW, using %v", database.MessageEditWindow)
+	}
+
+	// Reverse proxies allowed to report the client IP stored with login sessions
+	if err := auth.SetTrustedProxies(getEnv("TRUSTED_PROXIES", "")); err != nil {
+		log.Printf("⚠️ Invalid TRUSTED_PROXIES, forwarding headers are ignored: %v", err)
+	}
+
 	// Initialize rate limiter
 	var rateLimiter *middleware.RateLimiter
 	if redisClient != nil {
@@ -220,9 +272,19 @@ func main() {
 	// Create hub
 	hub := websocket.NewHub()
 
+	// Set database for room membership and room message persistence
+	hub.SetDatabase(db)
+
 	// Set hub for friends service (for realtime notifications)
 	friendsService.SetHub(hub)
 
+	// Set hub for auth handler (disconnect revoked sessions)
+	authHandler.SetHub(hub)
+
+	// Initialize rooms service (hub for realtime room events)
+	roomsService := handlers.NewRoomsService(db)
+	roomsService.SetHub(hub)
+
 	// Set cache service for online status tracking
 	if cacheService != nil {
 		hub.SetCacheService(cacheService)
@@ -275,11 +337,21 @@ func main() {
 		mux.Handle("/api/friends", relaxedLimit(auth.AuthMiddleware(handlers.FriendsHandler(friendsService))))
 		mux.Handle("/api/friends/search", relaxedLimit(auth.AuthMiddleware(handlers.SearchUsersHandler(friendsService))))
 		mux.Handle("/api/friends/requests", relaxedLimit(auth.AuthMiddleware(handlers.GetFriendRequestsHandler(friendsService))))
+		mux.Handle("/api/friends/requests/sent", relaxedLimit(auth.AuthMiddleware(handlers.GetSentFriendRequestsHandler(friendsService))))
+		mux.Handle("/api/friends/blocked", relaxedLimit(auth.AuthMiddleware(handlers.GetBlockedUsersHandler(friendsService))))
+		mux.Handle("/api/friends/suggestions", relaxedLimit(auth.AuthMiddleware(handlers.GetFriendSuggestionsHandler(friendsService))))
+		mux.Handle("/api/presence/devices", relaxedLimit(auth.AuthMiddleware(handlers.DevicesHandler(friendsService))))
 
 		// Write endpoints (POST) - Normal limit (60 req/min)
 		mux.Handle("/api/friends/request", normalLimit(auth.AuthMiddleware(handlers.SendFriendRequestHandler(friendsService))))
 		mux.Handle("/api/friends/accept", normalLimit(auth.AuthMiddleware(handlers.AcceptFriendRequestHandler(friendsService))))
 		mux.Handle("/api/friends/reject", normalLimit(auth.AuthMiddleware(handlers.RejectFriendRequestHandler(friendsService))))
+		mux.Handle("/api/friends/remove", normalLimit(auth.AuthMiddleware(handlers.RemoveFriendHandler(friendsService))))
+		mux.Handle("/api/friends/cancel", normalLimit(auth.AuthMiddleware(handlers.CancelFriendRequestHandler(friendsService))))
+		mux.Handle("/api/friends/block", normalLimit(auth.AuthMiddleware(handlers.BlockUserHandler(friendsService))))
+		mux.Handle("/api/friends/unblock", normalLimit(auth.AuthMiddleware(handlers.UnblockUserHandler(friendsService))))
+		mux.Handle("/api/presence", normalLimit(auth.AuthMiddleware(handlers.PresenceHandler(friendsService))))
+		mux.Handle("/api/status", normalLimit(auth.AuthMiddleware(handlers.StatusHandler(friendsService))))
 	} else {
 		// Fallback without rate limiting
 		mux.HandleFunc("/api/friends", auth.AuthMiddleware(handlers.FriendsHandler(friendsService)))
@@ -288,18 +360,73 @@ func main() {
 		mux.HandleFunc("/api/friends/requests", auth.AuthMiddleware(handlers.GetFriendRequestsHandler(friendsService)))
 		mux.HandleFunc("/api/friends/accept", auth.AuthMiddleware(handlers.AcceptFriendRequestHandler(friendsService)))
 		mux.HandleFunc("/api/friends/reject", auth.AuthMiddleware(handlers.RejectFriendRequestHandler(friendsService)))
+		mux.HandleFunc("/api/friends/requests/sent", auth.AuthMiddleware(handlers.GetSentFriendRequestsHandler(friendsService)))
+		mux.HandleFunc("/api/friends/remove", auth.AuthMiddleware(handlers.RemoveFriendHandler(friendsService)))
+		mux.HandleFunc("/api/friends/cancel", auth.AuthMiddleware(handlers.CancelFriendRequestHandler(friendsService)))
+		mux.HandleFunc("/api/friends/block", auth.AuthMiddleware(handlers.BlockUserHandler(friendsService)))
+		mux.HandleFunc("/api/friends/unblock", auth.AuthMiddleware(handlers.UnblockUserHandler(friendsService)))
+		mux.HandleFunc("/api/friends/blocked", auth.AuthMiddleware(handlers.GetBlockedUsersHandler(friendsService)))
+		mux.HandleFunc("/api/friends/suggestions", auth.AuthMiddleware(handlers.GetFriendSuggestionsHandler(friendsService)))
+		mux.HandleFunc("/api/presence", auth.AuthMiddleware(handlers.PresenceHandler(friendsService)))
+		mux.HandleFunc("/api/presence/devices", auth.AuthMiddleware(handlers.DevicesHandler(friendsService)))
+		mux.HandleFunc("/api/status", auth.AuthMiddleware(handlers.StatusHandler(friendsService)))
 	}
 
 	// Messages API with relaxed rate limiting (read-heavy, protected)
 	if rateLimiter != nil {
+		normalLimit := rateLimiter.RateLimitMiddleware(middleware.NormalLimit)
 		relaxedLimit := rateLimiter.RateLimitMiddleware(middleware.RelaxedLimit)
 
 		mux.Handle("/api/messages/history", relaxedLimit(auth.AuthMiddleware(handlers.GetMessageHistoryHandler(db))))
+		mux.Handle("/api/messages/thread", relaxedLimit(auth.AuthMiddleware(handlers.GetThreadHistoryHandler(db))))
+		mux.Handle("/api/messages/search", relaxedLimit(auth.AuthMiddleware(handlers.SearchMessagesHandler(db))))
 		mux.Handle("/api/conversations", relaxedLimit(auth.AuthMiddleware(handlers.GetConversationsHandler(db))))
+		mux.Handle("/api/messages/read", relaxedLimit(auth.AuthMiddleware(handlers.MarkReadHandler(db, hub))))
+		mux.Handle("/api/messages/send", normalLimit(auth.AuthMiddleware(handlers.SendMessageHandler(db, hub))))
+		mux.Handle("/api/messages/edit", normalLimit(auth.AuthMiddleware(handlers.EditMessageHandler(db, hub))))
+		mux.Handle("/api/messages/edits", relaxedLimit(auth.AuthMiddleware(handlers.MessageEditsHandler(db))))
+		mux.Handle("/api/messages/delete", normalLimit(auth.AuthMiddleware(handlers.DeleteMessageHandler(db, hub))))
 	} else {
 		// Fallback without rate limiting
 		mux.HandleFunc("/api/messages/history", auth.AuthMiddleware(handlers.GetMessageHistoryHandler(db)))
+		mux.HandleFunc("/api/messages/thread", auth.AuthMiddleware(handlers.GetThreadHistoryHandler(db)))
+		mux.HandleFunc("/api/messages/search", auth.AuthMiddleware(handlers.SearchMessagesHandler(db)))
 		mux.HandleFunc("/api/conversations", auth.AuthMiddleware(handlers.GetConversationsHandler(db)))
+		mux.HandleFunc("/api/messages/read", auth.AuthMiddleware(handlers.MarkReadHandler(db, hub)))
+		mux.HandleFunc("/api/messages/send", auth.AuthMiddleware(handlers.SendMessageHandler(db, hub)))
+		mux.HandleFunc("/api/messages/edit", auth.AuthMiddleware(handlers.EditMessageHandler(db, hub)))
+		mux.HandleFunc("/api/messages/edits", auth.AuthMiddleware(handlers.MessageEditsHandler(db)))
+		mux.HandleFunc("/api/messages/delete", auth.AuthMiddleware(handlers.DeleteMessageHandler(db, hub)))
+	}
+
+	// Rooms API with rate limiting (protected)
+	if rateLimiter != nil {
+		normalLimit := rateLimiter.RateLimitMiddleware(middleware.NormalLimit)
+		relaxedLimit := rateLimiter.RateLimitMiddleware(middleware.RelaxedLimit)
+
+		// Read endpoints (GET) - Relaxed limit
+		mux.Handle("/api/rooms/directory", relaxedLimit(auth.AuthMiddleware(handlers.RoomDirectoryHandler(roomsService))))
+
+		// Write endpoints (POST/PUT/DELETE) - Normal limit
+		mux.Handle("/api/rooms", normalLimit(auth.AuthMiddleware(handlers.RoomsHandler(roomsService))))
+		mux.Handle("/api/rooms/update", normalLimit(auth.AuthMiddleware(handlers.UpdateRoomHandler(roomsService))))
+		mux.Handle("/api/rooms/delete", normalLimit(auth.AuthMiddleware(handlers.DeleteRoomHandler(roomsService))))
+
+		// Membership & moderation
+		mux.Handle("/api/rooms/members", relaxedLimit(auth.AuthMiddleware(handlers.RoomMembersHandler(roomsService))))
+		for _, action := range roomMemberActions {
+			mux.Handle("/api/rooms/members/"+action, normalLimit(auth.AuthMiddleware(handlers.RoomMemberActionHandler(roomsService, action))))
+		}
+	} else {
+		// Fallback without rate limiting
+		mux.HandleFunc("/api/rooms", auth.AuthMiddleware(handlers.RoomsHandler(roomsService)))
+		mux.HandleFunc("/api/rooms/directory", auth.AuthMiddleware(handlers.RoomDirectoryHandler(roomsService)))
+		mux.HandleFunc("/api/rooms/update", auth.AuthMiddleware(handlers.UpdateRoomHandler(roomsService)))
+		mux.HandleFunc("/api/rooms/delete", auth.AuthMiddleware(handlers.DeleteRoomHandler(roomsService)))
+		mux.HandleFunc("/api/rooms/members", auth.AuthMiddleware(handlers.RoomMembersHandler(roomsService)))
+		for _, action := range roomMemberActions {
+			mux.HandleFunc("/api/rooms/members/"+action, auth.AuthMiddleware(handlers.RoomMemberActionHandler(roomsService, action)))
+		}
 	}
 
 	addr := ":8080"
@@ -314,6 +441,7 @@ func main() {
 	log.Printf("👥 Friends API: http://localhost%s/api/friends", addr)
 	log.Printf("🔍 Search API: http://localhost%s/api/friends/search?q=keyword", addr)
 	log.Printf("📨 Friend Requests: http://localhost%s/api/friends/requests", addr)
+	log.Printf("🏠 Rooms API: http://localhost%s/api/rooms", addr)
 	log.Printf("🎯 Server starting on %s", addr)
 
 	// Wrap with CORS middleware
@@ -0,0 +1,28 @@
+-- ============================================
+-- Room moderation: roles, mutes and bans
+-- ============================================
+
+-- Chỉ cho phép 3 role hợp lệ
+ALTER TABLE room_members DROP CONSTRAINT IF EXISTS room_members_role_check;
+ALTER TABLE room_members
+    ADD CONSTRAINT room_members_role_check CHECK (role IN ('admin', 'moderator', 'member'));
+
+-- Mute: thành viên vẫn đọc được nhưng không gửi được tin nhắn tới khi hết hạn
+ALTER TABLE room_members ADD COLUMN IF NOT EXISTS muted_until TIMESTAMP;
+
+-- ============================================
+-- Table: room_bans
+-- Người bị ban bị xóa khỏi room và không thể join lại tới khi hết hạn
+-- ============================================
+CREATE TABLE IF NOT EXISTS room_bans (
+    id SERIAL PRIMARY KEY,
+    room_id INT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    banned_by INT REFERENCES users(id) ON DELETE SET NULL,
+    reason TEXT,
+    expires_at TIMESTAMP, -- NULL = permanent
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(room_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_room_bans_user ON room_bans(user_id, room_id);
@@ -0,0 +1,9 @@
+-- ============================================
+-- Read receipts for direct messages
+-- ============================================
+
+-- Thời điểm người nhận đã đọc tin nhắn (NULL = chưa đọc)
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS read_at TIMESTAMP;
+
+-- Đánh dấu các tin nhắn cũ đã đọc (nếu có) với thời điểm tạo
+UPDATE messages SET read_at = created_at WHERE is_read = true AND read_at IS NULL;
@@ -0,0 +1,11 @@
+-- ============================================
+-- Idempotent sends: client-generated message IDs
+-- ============================================
+
+-- ID do client tạo cho mỗi tin nhắn, dùng để bỏ qua khi client gửi lại (retry)
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS client_msg_id VARCHAR(64);
+
+-- Mỗi người gửi chỉ dùng một client_msg_id một lần
+CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_client_msg_id
+ON messages(from_user_id, client_msg_id)
+WHERE client_msg_id IS NOT NULL;
@@ -0,0 +1,14 @@
+-- ============================================
+-- Offline delivery queue for direct messages
+-- ============================================
+
+-- Thời điểm tin nhắn được gửi tới thiết bị của người nhận (NULL = đang chờ)
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS delivered_at TIMESTAMP;
+
+-- Tin nhắn cũ coi như đã gửi, không đẩy lại khi user kết nối
+UPDATE messages SET delivered_at = created_at WHERE delivered_at IS NULL;
+
+-- Index cho hàng đợi tin nhắn chưa gửi của mỗi user
+CREATE INDEX IF NOT EXISTS idx_messages_undelivered
+ON messages(to_user_id, id)
+WHERE delivered_at IS NULL AND to_user_id IS NOT NULL;
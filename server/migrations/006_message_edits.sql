@@ -0,0 +1,20 @@
+-- ============================================
+-- Message editing with edit history
+-- ============================================
+
+-- Thời điểm tin nhắn được sửa lần cuối (NULL = chưa sửa)
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS edited_at TIMESTAMP;
+
+-- ============================================
+-- Table: message_edits
+-- Lưu nội dung cũ mỗi lần tin nhắn bị sửa
+-- ============================================
+CREATE TABLE IF NOT EXISTS message_edits (
+    id SERIAL PRIMARY KEY,
+    message_id INT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    previous_text TEXT NOT NULL,
+    edited_by INT REFERENCES users(id) ON DELETE SET NULL,
+    edited_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_edits_message ON message_edits(message_id, edited_at);
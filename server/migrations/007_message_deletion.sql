@@ -0,0 +1,21 @@
+-- ============================================
+-- Message deletion: delete for me / delete for everyone
+-- ============================================
+
+-- Thu hồi tin nhắn với mọi người (NULL = chưa thu hồi).
+-- Nội dung gốc vẫn được giữ, các truy vấn lịch sử trả về tombstone.
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS deleted_by INT REFERENCES users(id) ON DELETE SET NULL;
+
+-- ============================================
+-- Table: message_hidden
+-- Tin nhắn mà người dùng đã xóa ở phía mình ("delete for me")
+-- ============================================
+CREATE TABLE IF NOT EXISTS message_hidden (
+    message_id INT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    hidden_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (message_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_hidden_user ON message_hidden(user_id);
@@ -0,0 +1,13 @@
+-- ============================================
+-- Table: message_reactions
+-- Emoji reaction của người dùng trên tin nhắn (mỗi emoji một lần/người)
+-- ============================================
+CREATE TABLE IF NOT EXISTS message_reactions (
+    message_id INT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    emoji VARCHAR(32) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (message_id, user_id, emoji)
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_reactions_message ON message_reactions(message_id, emoji);
@@ -0,0 +1,12 @@
+-- ============================================
+-- Threaded replies and quoted messages
+-- ============================================
+
+-- Tin nhắn được trích dẫn / trả lời (NULL = không trả lời tin nào)
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS reply_to_id INT REFERENCES messages(id) ON DELETE SET NULL;
+
+-- Tin nhắn gốc của thread (NULL = tin nhắn nằm trong hội thoại chính)
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS thread_root_id INT REFERENCES messages(id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS idx_messages_thread_root ON messages(thread_root_id, id)
+    WHERE thread_root_id IS NOT NULL;
@@ -0,0 +1,39 @@
+-- ============================================
+-- Full-text message search (không phân biệt dấu tiếng Việt)
+-- ============================================
+
+CREATE EXTENSION IF NOT EXISTS unaccent;
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- unaccent() chỉ là STABLE nên không dùng được trong index,
+-- wrapper IMMUTABLE cố định dictionary để dùng cho index trigram
+CREATE OR REPLACE FUNCTION immutable_unaccent(text)
+RETURNS text
+LANGUAGE sql IMMUTABLE PARALLEL SAFE STRICT
+AS $$ SELECT public.unaccent('public.unaccent'::regdictionary, $1) $$;
+
+-- Cấu hình full-text: tách từ như 'simple' rồi bỏ dấu từng token
+-- ("Hà Nội", "ha noi", "HA NOI" cho cùng lexeme). ts_headline với cấu hình này
+-- vẫn highlight được trên nội dung gốc có dấu.
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_ts_config WHERE cfgname = 'vn_unaccent') THEN
+        CREATE TEXT SEARCH CONFIGURATION vn_unaccent (COPY = simple);
+        ALTER TEXT SEARCH CONFIGURATION vn_unaccent
+            ALTER MAPPING FOR hword, hword_part, word WITH unaccent, simple;
+    END IF;
+END
+$$;
+
+-- Vector tìm kiếm của nội dung tin nhắn
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (to_tsvector('vn_unaccent'::regconfig, message_text)) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_messages_search_vector ON messages USING GIN (search_vector);
+
+-- Index 'english' cũ (001_init) không dùng được cho tiếng Việt
+DROP INDEX IF EXISTS idx_messages_text_search;
+
+-- Tìm theo chuỗi con / gõ thiếu (trigram trên nội dung đã bỏ dấu)
+CREATE INDEX IF NOT EXISTS idx_messages_text_trgm ON messages
+    USING GIN (immutable_unaccent(lower(message_text)) gin_trgm_ops);
@@ -0,0 +1,12 @@
+-- ============================================
+-- User search: trigram similarity + exact email lookup
+-- ============================================
+
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- Tìm username theo độ tương đồng / chuỗi con (LIKE '%q%', %, <%)
+CREATE INDEX IF NOT EXISTS idx_users_username_trgm ON users
+    USING GIN (LOWER(username) gin_trgm_ops);
+
+-- Chỉ cho phép tra cứu email chính xác (không tìm theo chuỗi con)
+CREATE INDEX IF NOT EXISTS idx_users_email_lower ON users(LOWER(email));
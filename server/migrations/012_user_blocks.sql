@@ -0,0 +1,62 @@
+-- ============================================
+-- Blocking users
+-- friendships.status = 'blocked': user_id là người chặn, friend_id là người bị chặn.
+-- Hai người có thể chặn nhau (hai dòng riêng biệt).
+-- ============================================
+
+-- Function: Kiểm tra một trong hai người đã chặn người kia chưa
+CREATE OR REPLACE FUNCTION is_blocked(user1_id INT, user2_id INT)
+RETURNS BOOLEAN AS $$
+BEGIN
+    RETURN EXISTS (
+        SELECT 1 FROM friendships
+        WHERE status = 'blocked'
+        AND (
+            (user_id = user1_id AND friend_id = user2_id)
+            OR (user_id = user2_id AND friend_id = user1_id)
+        )
+    );
+END;
+$$ LANGUAGE plpgsql;
+
+-- Function: Kiểm tra có thể gửi tin nhắn trực tiếp không (thêm kiểm tra chặn)
+CREATE OR REPLACE FUNCTION can_send_direct_message(sender_id INT, receiver_id INT)
+RETURNS BOOLEAN AS $$
+BEGIN
+    -- Không thể gửi cho chính mình
+    IF sender_id = receiver_id THEN
+        RETURN FALSE;
+    END IF;
+
+    -- Không thể nhắn tin khi một trong hai đã chặn người kia
+    IF is_blocked(sender_id, receiver_id) THEN
+        RETURN FALSE;
+    END IF;
+
+    -- Phải là bạn bè mới được gửi tin nhắn trực tiếp
+    RETURN are_friends(sender_id, receiver_id);
+END;
+$$ LANGUAGE plpgsql;
+
+-- Trigger Function: Validate direct message (thông báo lỗi riêng khi bị chặn)
+CREATE OR REPLACE FUNCTION validate_direct_message()
+RETURNS TRIGGER AS $$
+BEGIN
+    -- Nếu là tin nhắn trực tiếp (có to_user_id và không có room_id)
+    IF NEW.to_user_id IS NOT NULL AND NEW.room_id IS NULL THEN
+        IF is_blocked(NEW.from_user_id, NEW.to_user_id) THEN
+            RAISE EXCEPTION 'Cannot send direct message: user % and user % have a block between them', NEW.from_user_id, NEW.to_user_id;
+        END IF;
+        -- Kiểm tra có phải bạn bè không
+        IF NOT can_send_direct_message(NEW.from_user_id, NEW.to_user_id) THEN
+            RAISE EXCEPTION 'Cannot send direct message: users must be friends first. User % tried to send to user %', NEW.from_user_id, NEW.to_user_id;
+        END IF;
+    END IF;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Danh sách người bị chặn của một người dùng
+CREATE INDEX IF NOT EXISTS idx_friendships_blocked ON friendships(user_id, created_at DESC)
+    WHERE status = 'blocked';
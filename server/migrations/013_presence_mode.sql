@@ -0,0 +1,11 @@
+-- ============================================
+-- Presence privacy
+-- Trạng thái online chỉ gửi cho bạn bè và người chung room.
+-- presence_mode: 'visible' (mặc định), 'invisible' (luôn hiện offline), 'dnd' (không làm phiền)
+-- ============================================
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS presence_mode VARCHAR(20) NOT NULL DEFAULT 'visible';
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_presence_mode_check;
+ALTER TABLE users
+    ADD CONSTRAINT users_presence_mode_check CHECK (presence_mode IN ('visible', 'invisible', 'dnd'));
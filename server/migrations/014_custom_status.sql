@@ -0,0 +1,14 @@
+-- ============================================
+-- Custom status
+-- Trạng thái tùy chỉnh của người dùng ("Đang họp đến 3h chiều").
+-- status_expires_at NULL = không tự hết hạn; khi hết hạn server tự xóa status.
+-- ============================================
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status_text VARCHAR(140);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status_emoji VARCHAR(32);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status_expires_at TIMESTAMP;
+
+-- Index: tìm các status đã hết hạn
+CREATE INDEX IF NOT EXISTS idx_users_status_expires
+    ON users(status_expires_at)
+    WHERE status_expires_at IS NOT NULL;
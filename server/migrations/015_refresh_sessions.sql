@@ -0,0 +1,23 @@
+-- ============================================
+-- Refresh tokens & sessions
+-- Mỗi lần đăng nhập tạo một dòng user_sessions. refresh_token lưu SHA-256 của
+-- refresh token (không lưu token gốc), đổi mới sau mỗi lần refresh.
+-- Token cũ được giữ trong revoked_refresh_tokens để phát hiện dùng lại
+-- (token bị đánh cắp): khi đó cả session bị thu hồi.
+-- ============================================
+
+-- token (access token) không còn được lưu
+ALTER TABLE user_sessions ALTER COLUMN token DROP NOT NULL;
+ALTER TABLE user_sessions ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sessions_refresh_token ON user_sessions(refresh_token);
+
+-- Table: revoked_refresh_tokens
+-- Refresh token đã được đổi mới, dùng lại = session bị lộ
+CREATE TABLE IF NOT EXISTS revoked_refresh_tokens (
+    token_hash VARCHAR(64) PRIMARY KEY,
+    session_id INT NOT NULL REFERENCES user_sessions(id) ON DELETE CASCADE,
+    revoked_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_revoked_refresh_tokens_session ON revoked_refresh_tokens(session_id);